@@ -0,0 +1,111 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PauseStrategy defines how the pods of a namespace are suspended
+type PauseStrategy string
+
+const (
+	// PauseStrategyDelete deletes running pods, pods recreated by their controller are parked using the suspend mode of the controller
+	PauseStrategyDelete PauseStrategy = "delete"
+
+	// PauseStrategyScale scales Deployments and StatefulSets to zero replicas, all other pods are suspended like with PauseStrategyDelete
+	PauseStrategyScale PauseStrategy = "scale"
+
+	// PauseStrategyGate suspends pods like PauseStrategyDelete but always parks pods using a scheduling gate
+	PauseStrategyGate PauseStrategy = "gate"
+)
+
+// NamespacePausePolicySpec defines the desired state of NamespacePausePolicy
+type NamespacePausePolicySpec struct {
+	// Suspend is the desired state of the namespace, it takes precedence over the k8s-pause/suspend annotation
+	// +optional
+	Suspend bool `json:"suspend"`
+
+	// Profiles are the ResumeProfiles active while the namespace is resumed, it takes precedence over the k8s-pause/profile annotation
+	// +optional
+	Profiles []string `json:"profiles,omitempty"`
+
+	// Strategy defines how pods are suspended, either delete, scale or gate.
+	// Replicas of Deployments and StatefulSets scaled down by the scale strategy are restored once the namespace is resumed.
+	// Workloads whose pod template is excluded or has the k8s-pause/ignore annotation are not scaled.
+	// +kubebuilder:validation:Enum=delete;scale;gate
+	// +kubebuilder:default:=delete
+	// +optional
+	Strategy PauseStrategy `json:"strategy,omitempty"`
+
+	// Ordering groups pods by their labels. On resume the groups are resumed in order and a group is only resumed once all pods of the previous groups are ready.
+	// Pods not matched by any group are resumed last. Pods are suspended in reverse order.
+	// Ordering does not apply to Deployments and StatefulSets scaled by the scale strategy, they are scaled down and restored at once.
+	// +optional
+	Ordering []metav1.LabelSelector `json:"ordering,omitempty"`
+
+	// GracePeriodSeconds overrides the termination grace period of pods deleted while suspending the namespace
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	GracePeriodSeconds *int64 `json:"gracePeriodSeconds,omitempty"`
+
+	// Exclusions exclude pods by their labels, excluded pods are neither suspended nor resumed like pods with the k8s-pause/ignore annotation
+	// +optional
+	Exclusions []metav1.LabelSelector `json:"exclusions,omitempty"`
+}
+
+// NamespacePausePolicyStatus defines the observed state of NamespacePausePolicy
+type NamespacePausePolicyStatus struct {
+	// Conditions holds the conditions of the NamespacePausePolicy
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// ObservedGeneration is the last generation reconciled by the controller
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Suspend",type="boolean",JSONPath=".spec.suspend",description=""
+// +kubebuilder:printcolumn:name="Profiles",type="string",JSONPath=".spec.profiles",description=""
+// +kubebuilder:printcolumn:name="Strategy",type="string",JSONPath=".spec.strategy",description=""
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description=""
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description=""
+
+// NamespacePausePolicy defines how the namespace it is created in is suspended and resumed.
+// A namespace should have a single policy, if there are multiple policies the oldest one takes effect.
+type NamespacePausePolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NamespacePausePolicySpec   `json:"spec,omitempty"`
+	Status NamespacePausePolicyStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// NamespacePausePolicyList contains a list of NamespacePausePolicy
+type NamespacePausePolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NamespacePausePolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NamespacePausePolicy{}, &NamespacePausePolicyList{})
+}
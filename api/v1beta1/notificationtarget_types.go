@@ -0,0 +1,109 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NotificationFormat defines the payload sent to a NotificationTarget
+type NotificationFormat string
+
+const (
+	// NotificationFormatJSON sends the notification as json object
+	NotificationFormatJSON NotificationFormat = "json"
+
+	// NotificationFormatSlack sends the notification as Slack compatible message
+	NotificationFormatSlack NotificationFormat = "slack"
+)
+
+// NotificationEvent is the type of a notification
+type NotificationEvent string
+
+const (
+	// NotificationEventSuspended is sent once all pods of a namespace are suspended
+	NotificationEventSuspended NotificationEvent = "Suspended"
+
+	// NotificationEventResumed is sent once a suspended namespace is resumed
+	NotificationEventResumed NotificationEvent = "Resumed"
+
+	// NotificationEventFailed is sent if a namespace could not be suspended or resumed
+	NotificationEventFailed NotificationEvent = "Failed"
+)
+
+// NotificationTargetSpec defines the desired state of NotificationTarget
+type NotificationTargetSpec struct {
+	// Address is the http(s) url notifications are posted to
+	// +kubebuilder:validation:Pattern=`^https?://`
+	// +optional
+	Address string `json:"address,omitempty"`
+
+	// SecretRef references a secret in the same namespace holding the url in the key address, it takes precedence over Address.
+	// Use this for addresses which contain credentials like Slack incoming webhooks.
+	// +optional
+	SecretRef *corev1.LocalObjectReference `json:"secretRef,omitempty"`
+
+	// Format of the payload, either json or slack
+	// +kubebuilder:validation:Enum=json;slack
+	// +kubebuilder:default:=json
+	// +optional
+	Format NotificationFormat `json:"format,omitempty"`
+
+	// Template is a go template rendering the json payload, it takes precedence over Format.
+	// The notification is available as .Event, .Namespace, .Profile, .Message and .Timestamp, values can be quoted using the json function.
+	// +optional
+	Template string `json:"template,omitempty"`
+
+	// Headers are added to each request
+	// +optional
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Events limits the notifications to the given events, all events are sent if empty
+	// +optional
+	Events []NotificationEvent `json:"events,omitempty"`
+
+	// Retries is the number of retries if a notification could not be delivered
+	// +kubebuilder:default:=3
+	// +optional
+	Retries *int32 `json:"retries,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="Format",type="string",JSONPath=".spec.format",description=""
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description=""
+
+// NotificationTarget receives notifications about suspend and resume of the namespace it is created in
+type NotificationTarget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NotificationTargetSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// NotificationTargetList contains a list of NotificationTarget
+type NotificationTargetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NotificationTarget `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NotificationTarget{}, &NotificationTargetList{})
+}
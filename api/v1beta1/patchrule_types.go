@@ -17,20 +17,130 @@ limitations under the License.
 package v1beta1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// ProfileSelectors select the pods of a ResumeProfile.
+// A pod is matched if it matches any of the selectors and none of the exclude selectors.
+type ProfileSelectors struct {
+	// PodSelector matches pods by their labels
+	// +optional
+	PodSelector []metav1.LabelSelector `json:"podSelector,omitempty"`
+
+	// ExcludeSelector excludes pods by their labels, it takes precedence over all other selectors and included profiles
+	// +optional
+	ExcludeSelector []metav1.LabelSelector `json:"excludeSelector,omitempty"`
+
+	// Workloads matches pods by the workload they belong to
+	// +optional
+	Workloads []WorkloadSelector `json:"workloads,omitempty"`
+
+	// Images matches pods which have any container using an image matching one of the glob patterns, for instance docker.io/library/postgres:*.
+	// The wildcard * matches any sequence of characters including /, ? matches a single character.
+	// +optional
+	Images []string `json:"images,omitempty"`
+
+	// PodNames matches pods by one of the regular expressions.
+	// Pods created by a controller have no name yet while they are admitted, the regular expression is matched against the generateName of such pods.
+	// The random suffix of their name is never matched.
+	// +optional
+	PodNames []string `json:"podNames,omitempty"`
+}
+
 // ResumeProfileSpec defines the desired state of ResumeProfile
 type ResumeProfileSpec struct {
-	// Prometheus holds information about where to find prometheus
+	ProfileSelectors `json:",inline"`
+
+	// Includes references other ResumeProfiles in the same namespace, pods matched by any of them are matched by this profile as well
+	// +optional
+	Includes []string `json:"includes,omitempty"`
+
+	// Overrides change the size of Deployments and StatefulSets while the profile is active.
+	// The original values are restored once the profile is no longer active. The first matching override applies.
+	// +optional
+	Overrides []WorkloadOverride `json:"overrides,omitempty"`
+}
+
+// WorkloadOverride changes the replicas and container resources of matching Deployments and StatefulSets
+type WorkloadOverride struct {
+	// Selector matches Deployments and StatefulSets by their labels
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// Workloads matches Deployments and StatefulSets by their name, other kinds are ignored
+	// +optional
+	Workloads []WorkloadSelector `json:"workloads,omitempty"`
+
+	// Replicas overrides the number of replicas
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Resources overrides requests and limits of the containers
+	// +optional
+	Resources []ContainerResourcesOverride `json:"resources,omitempty"`
+}
+
+// ContainerResourcesOverride overrides requests and limits of containers, resources which are not listed are kept
+type ContainerResourcesOverride struct {
+	// Containers limits the override to the containers with the given names, all containers are overridden if empty
+	// +optional
+	Containers []string `json:"containers,omitempty"`
+
+	// Requests overrides the given resource requests
+	// +optional
+	Requests corev1.ResourceList `json:"requests,omitempty"`
+
+	// Limits overrides the given resource limits
+	// +optional
+	Limits corev1.ResourceList `json:"limits,omitempty"`
+}
+
+// WorkloadSelector matches pods owned by a workload
+type WorkloadSelector struct {
+	// Kind of the workload, for instance Deployment, StatefulSet, DaemonSet, Job or ReplicaSet.
+	// Pods owned by a ReplicaSet of a Deployment are matched by the Deployment.
 	// +required
-	PodSelector []metav1.LabelSelector `json:"podSelector"`
+	Kind string `json:"kind"`
+
+	// Name of the workload
+	// +required
+	Name string `json:"name"`
+}
+
+// ResolvedSelectors are the selectors and overrides of a profile or one of its includes.
+// The exclude selectors of all profiles along the include chain are applied.
+type ResolvedSelectors struct {
+	// Profile is the name of the profile the selectors originate from
+	Profile string `json:"profile"`
+
+	ProfileSelectors `json:",inline"`
+
+	// Overrides of the profile
+	// +optional
+	Overrides []WorkloadOverride `json:"overrides,omitempty"`
+}
+
+// ResumeProfileStatus defines the observed state of ResumeProfile
+type ResumeProfileStatus struct {
+	// Resolved is the flattened view of the profile including all profiles it includes, a pod is matched if it is matched by any of the entries
+	// +optional
+	Resolved []ResolvedSelectors `json:"resolved,omitempty"`
+
+	// Conditions holds the conditions of the ResumeProfile
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// ObservedGeneration is the last generation reconciled by the controller
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
-// +kubebuilder:printcolumn:name="Active",type="string",JSONPath=".status.conditions[?(@.type==\"Active\")].status",description=""
-// +kubebuilder:printcolumn:name="Reason",type="string",JSONPath=".status.conditions[?(@.type==\"Active\")].reason",description=""
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description=""
+// +kubebuilder:printcolumn:name="Reason",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].reason",description=""
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description=""
 
 // ResumeProfile is the Schema for the patchrules API
@@ -38,7 +148,8 @@ type ResumeProfile struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec ResumeProfileSpec `json:"spec,omitempty"`
+	Spec   ResumeProfileSpec   `json:"spec,omitempty"`
+	Status ResumeProfileStatus `json:"status,omitempty"`
 }
 
 //+kubebuilder:object:root=true
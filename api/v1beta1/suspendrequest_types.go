@@ -0,0 +1,122 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// RequestedByAnnotation holds the user who created a SuspendRequest, it is managed by the webhook
+	RequestedByAnnotation = "pause.infra.doodle.com/requested-by"
+
+	// ApprovedByAnnotation holds the user who approved a SuspendRequest, it is managed by the webhook
+	ApprovedByAnnotation = "pause.infra.doodle.com/approved-by"
+)
+
+// SuspendRequestPhase describes the progress of a SuspendRequest
+type SuspendRequestPhase string
+
+const (
+	// SuspendRequestPending means the request was not yet executed
+	SuspendRequestPending SuspendRequestPhase = "Pending"
+
+	// SuspendRequestWaitingForApproval means the request needs to be approved before it is executed
+	SuspendRequestWaitingForApproval SuspendRequestPhase = "WaitingForApproval"
+
+	// SuspendRequestExecuted means the request was applied to the namespace
+	SuspendRequestExecuted SuspendRequestPhase = "Executed"
+
+	// SuspendRequestFailed means the request could not be applied to the namespace
+	SuspendRequestFailed SuspendRequestPhase = "Failed"
+)
+
+// SuspendRequestSpec defines the desired state of SuspendRequest
+type SuspendRequestSpec struct {
+	// Suspend the namespace if true, resume it if false
+	// +required
+	Suspend bool `json:"suspend"`
+
+	// Profile is the name of a ResumeProfile which gets activated for the namespace, multiple profiles are separated by comma
+	// +optional
+	Profile string `json:"profile,omitempty"`
+
+	// Reason describes why the namespace should be suspended or resumed
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Approved marks the request as approved, this is only required if the controller enforces approvals.
+	// The request can not be approved by the same user who created it.
+	// +optional
+	Approved bool `json:"approved,omitempty"`
+}
+
+// SuspendRequestStatus defines the observed state of SuspendRequest
+type SuspendRequestStatus struct {
+	// Phase of the request
+	// +optional
+	Phase SuspendRequestPhase `json:"phase,omitempty"`
+
+	// Message describes the current phase
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// RequestedBy is the user who created the request
+	// +optional
+	RequestedBy string `json:"requestedBy,omitempty"`
+
+	// ApprovedBy is the user who approved the request
+	// +optional
+	ApprovedBy string `json:"approvedBy,omitempty"`
+
+	// ExecutedAt is the time the request was applied to the namespace
+	// +optional
+	ExecutedAt *metav1.Time `json:"executedAt,omitempty"`
+
+	// ObservedGeneration is the last generation reconciled by the controller
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Suspend",type="boolean",JSONPath=".spec.suspend",description=""
+// +kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase",description=""
+// +kubebuilder:printcolumn:name="Requested By",type="string",JSONPath=".status.requestedBy",description=""
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description=""
+
+// SuspendRequest asks the controller to suspend or resume the namespace it is created in
+type SuspendRequest struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SuspendRequestSpec   `json:"spec,omitempty"`
+	Status SuspendRequestStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// SuspendRequestList contains a list of SuspendRequest
+type SuspendRequestList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SuspendRequest `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SuspendRequest{}, &SuspendRequestList{})
+}
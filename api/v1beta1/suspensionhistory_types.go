@@ -0,0 +1,85 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SuspensionTransition is a change of the suspend state of a namespace
+type SuspensionTransition struct {
+	// Suspended is true if the namespace got suspended, false if it got resumed
+	Suspended bool `json:"suspended"`
+
+	// Profile is the ResumeProfile which was active after the transition, multiple profiles are separated by comma
+	// +optional
+	Profile string `json:"profile,omitempty"`
+
+	// Actor is who changed the suspend state, either the user who created the SuspendRequest or the field manager of the namespace annotations
+	// +optional
+	Actor string `json:"actor,omitempty"`
+
+	// Request is the SuspendRequest which caused the transition
+	// +optional
+	Request string `json:"request,omitempty"`
+
+	// Timestamp is the time the transition was observed
+	Timestamp metav1.Time `json:"timestamp"`
+
+	// Pods is the number of pods in the namespace at the time of the transition
+	Pods int32 `json:"pods"`
+
+	// Duration is how long the namespace stayed in this state, it is set once the next transition happens
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+}
+
+// SuspensionHistoryStatus defines the observed state of SuspensionHistory
+type SuspensionHistoryStatus struct {
+	// Transitions holds the most recent transitions, the oldest ones are dropped once the limit is reached
+	// +optional
+	Transitions []SuspensionTransition `json:"transitions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Suspended",type="boolean",JSONPath=".status.transitions[-1:].suspended",description=""
+// +kubebuilder:printcolumn:name="Profile",type="string",JSONPath=".status.transitions[-1:].profile",description=""
+// +kubebuilder:printcolumn:name="Actor",type="string",JSONPath=".status.transitions[-1:].actor",description=""
+// +kubebuilder:printcolumn:name="Since",type="date",JSONPath=".status.transitions[-1:].timestamp",description=""
+
+// SuspensionHistory records the suspend and resume transitions of the namespace with the same name
+type SuspensionHistory struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status SuspensionHistoryStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// SuspensionHistoryList contains a list of SuspensionHistory
+type SuspensionHistoryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SuspensionHistory `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SuspensionHistory{}, &SuspensionHistoryList{})
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	webhookErrorsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "k8s_pause_webhook_errors_total",
+			Help: "Total number of pod admissions where the suspend state could not be determined, partitioned by the applied decision.",
+		},
+		[]string{"decision"},
+	)
+
+	notificationsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "k8s_pause_notifications_total",
+			Help: "Total number of notifications sent to notification targets, partitioned by result.",
+		},
+		[]string{"result"},
+	)
+
+	parkedCPURequests = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_pause_parked_cpu_requests_cores",
+			Help: "Sum of the CPU requests of all parked pods in a suspended namespace.",
+		},
+		[]string{"namespace"},
+	)
+
+	parkedMemoryRequests = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_pause_parked_memory_requests_bytes",
+			Help: "Sum of the memory requests of all parked pods in a suspended namespace.",
+		},
+		[]string{"namespace"},
+	)
+
+	suspendedSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8s_pause_suspended_seconds",
+			Help: "Duration a namespace is suspended for.",
+		},
+		[]string{"namespace"},
+	)
+
+	savedCPUHours = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "k8s_pause_saved_cpu_core_hours_total",
+			Help: "Estimated CPU core hours saved by suspending a namespace, based on the requests of the parked pods.",
+		},
+		[]string{"namespace"},
+	)
+
+	savedMemoryHours = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "k8s_pause_saved_memory_gibibyte_hours_total",
+			Help: "Estimated memory GiB hours saved by suspending a namespace, based on the requests of the parked pods.",
+		},
+		[]string{"namespace"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		webhookErrorsTotal,
+		notificationsTotal,
+		parkedCPURequests,
+		parkedMemoryRequests,
+		suspendedSeconds,
+		savedCPUHours,
+		savedMemoryHours,
+	)
+}
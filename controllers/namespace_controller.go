@@ -19,25 +19,36 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"strings"
+	"time"
 
 	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
 	"github.com/go-logr/logr"
+	"golang.org/x/time/rate"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 //+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=namespaces/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=core,resources=namespaces/finalizers,verbs=update
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=pause.infra.doodle.com,resources=resumeprofiles,verbs=get;list;watch
 
 const (
 	previousSchedulerName = "k8s-pause/previousScheduler"
@@ -46,23 +57,138 @@ const (
 
 // NamespaceReconciler reconciles a Namespace object
 type NamespaceReconciler struct {
-	Client client.WithWatch
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Client   client.WithWatch
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	Notifier *Notifier
+	opts     NamespaceReconcilerOptions
+	limiter  *rate.Limiter
+	savings  savingsObserver
+	history  recordedStates
 }
 
+// ResumeStrategy defines how pods owned by a controller are resumed
+type ResumeStrategy string
+
+const (
+	// ResumeStrategyRecreate deletes suspended pods and lets their controller create new ones
+	ResumeStrategyRecreate ResumeStrategy = "recreate"
+
+	// ResumeStrategyInPlace removes the scheduling gate from suspended pods,
+	// pods suspended by the k8s-pause scheduler are still recreated since the scheduler of a pod is immutable
+	ResumeStrategyInPlace ResumeStrategy = "in-place"
+)
+
 type NamespaceReconcilerOptions struct {
 	MaxConcurrentReconciles int
+	SuspendMode             SuspendMode
+	ResumeStrategy          ResumeStrategy
+
+	// PodsPerSecond limits the number of pod operations across all namespaces, zero means no limit
+	PodsPerSecond float64
+
+	// BatchSize limits the number of pod operations per reconciliation, zero means no limit.
+	// Remaining pods are processed in a subsequent reconciliation.
+	BatchSize int
+
+	// ResyncInterval defines how often suspended namespaces are verified for running pods, zero disables the resync
+	ResyncInterval time.Duration
+
+	// Protected namespaces are never suspended, even if they are annotated
+	Protected ProtectedNamespaces
+
+	// HistoryLimit is the number of transitions kept in the SuspensionHistory of a namespace, zero disables the history
+	HistoryLimit int
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *NamespaceReconciler) SetupWithManager(mgr ctrl.Manager, opts NamespaceReconcilerOptions) error {
+	r.opts = opts
+	if opts.PodsPerSecond > 0 {
+		r.limiter = rate.NewLimiter(rate.Limit(opts.PodsPerSecond), 1)
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&corev1.Namespace{}).
+		Watches(
+			&source.Kind{Type: &v1beta1.ResumeProfile{}},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForResumeProfile(mgr.GetClient())),
+		).
+		Watches(
+			&source.Kind{Type: &v1beta1.NamespacePausePolicy{}},
+			handler.EnqueueRequestsFromMapFunc(requestsForPausePolicy),
+		).
+		Watches(
+			&source.Kind{Type: &corev1.Pod{}},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForPod(mgr.GetClient())),
+			builder.WithPredicates(podChangePredicate()),
+		).
 		WithOptions(controller.Options{MaxConcurrentReconciles: opts.MaxConcurrentReconciles}).
 		Complete(r)
 }
 
+// requestsForResumeProfile enqueues the namespace of a ResumeProfile if any profile is active in the namespace,
+// the changed profile may be included by the active one
+func (r *NamespaceReconciler) requestsForResumeProfile(c client.Reader) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		var ns corev1.Namespace
+		if err := c.Get(context.TODO(), client.ObjectKey{Name: obj.GetNamespace()}, &ns); err != nil {
+			return nil
+		}
+
+		if state, _, err := suspendStateFor(context.TODO(), c, ns, r.opts.Protected); err != nil || state.Profile == "" {
+			return nil
+		}
+
+		return []reconcile.Request{{NamespacedName: client.ObjectKey{Name: ns.Name}}}
+	}
+}
+
+// requestsForPausePolicy enqueues the namespace of a NamespacePausePolicy
+func requestsForPausePolicy(obj client.Object) []reconcile.Request {
+	return []reconcile.Request{{NamespacedName: client.ObjectKey{Name: obj.GetNamespace()}}}
+}
+
+// requestsForPod enqueues the namespace of a pod if the namespace is suspended, has an active profile or the pod is parked
+func (r *NamespaceReconciler) requestsForPod(c client.Reader) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		if pod, ok := obj.(*corev1.Pod); ok && isPodParked(*pod) {
+			return []reconcile.Request{{NamespacedName: client.ObjectKey{Name: pod.Namespace}}}
+		}
+
+		var ns corev1.Namespace
+		if err := c.Get(context.TODO(), client.ObjectKey{Name: obj.GetNamespace()}, &ns); err != nil {
+			return nil
+		}
+
+		if state, _, err := suspendStateFor(context.TODO(), c, ns, r.opts.Protected); err != nil || (!state.Suspend && state.Profile == "") {
+			return nil
+		}
+
+		return []reconcile.Request{{NamespacedName: client.ObjectKey{Name: ns.Name}}}
+	}
+}
+
+// podChangePredicate filters pod events which may change whether a pod needs to be suspended or resumed
+func podChangePredicate() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return true
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return !reflect.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels()) ||
+				e.ObjectOld.GetAnnotations()[ignoreAnnotation] != e.ObjectNew.GetAnnotations()[ignoreAnnotation]
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return false
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.11.0/pkg/reconcile
 func (r *NamespaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -74,6 +200,9 @@ func (r *NamespaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 	err := r.Client.Get(ctx, req.NamespacedName, &ns)
 	if err != nil {
 		if errors.IsNotFound(err) {
+			r.history.forget(req.Name)
+			r.forgetSavings(req.Name)
+
 			// Request object not found, could have been deleted after reconcile request.
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 			// Return and don't requeue
@@ -83,116 +212,220 @@ func (r *NamespaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return reconcile.Result{}, err
 	}
 
-	var suspend bool
-	if suspended, ok := ns.Annotations[suspendedAnnotation]; ok {
-		if suspended == "true" {
-			suspend = true
+	state, policies, err := suspendStateFor(ctx, r.Client, ns, r.opts.Protected)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	var policy *v1beta1.NamespacePausePolicy
+	if len(policies) > 0 {
+		policy = &policies[0]
+	}
+
+	if err := r.reportPausePolicies(ctx, ns, policies, state); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if state.Protected {
+		if err := r.refuseProtected(ctx, &ns, policy, logger); err != nil {
+			return ctrl.Result{}, err
 		}
 	}
 
-	var profile *v1beta1.ResumeProfile
-	if p, ok := ns.Annotations[profileAnnotation]; ok {
-		profile = &v1beta1.ResumeProfile{}
-		err := r.Client.Get(ctx, client.ObjectKey{
-			Name:      p,
-			Namespace: req.Name,
-		}, profile)
+	if err := r.recordTransition(ctx, ns, state, policy); err != nil {
+		return ctrl.Result{}, err
+	}
 
+	var profile *resolvedProfile
+	if state.Profile != "" {
+		resolved, err := resolveResumeProfiles(ctx, r.getResumeProfile, req.Name, profileNames(state.Profile))
 		if err != nil {
 			return ctrl.Result{}, err
 		}
+
+		profile = &resolved
 	}
 
 	var res ctrl.Result
+	batch := newPodBatch(r.limiter, r.opts.BatchSize)
 
-	if suspend {
+	if state.Suspend {
 		logger.Info("make sure namespace is suspended")
-		res, err = r.suspend(ctx, ns, logger)
-	} else {
-		logger.Info("make sure namespace is resumed")
-		res, err = r.resume(ctx, ns, profile, logger)
+		if state.Strategy == v1beta1.PauseStrategyScale {
+			if err := r.scaleDown(ctx, ns, state, logger); err != nil {
+				r.notify(ctx, ns, v1beta1.NotificationEventFailed, state.Profile, fmt.Sprintf("failed to scale down workloads: %s", err))
+				return ctrl.Result{}, err
+			}
+		}
+
+		var requests corev1.ResourceList
+		res, requests, err = r.suspend(ctx, ns, state, batch, logger)
 		if err != nil {
+			r.notify(ctx, ns, v1beta1.NotificationEventFailed, state.Profile, fmt.Sprintf("failed to suspend namespace: %s", err))
+		}
+
+		if err != nil || !res.IsZero() {
 			return res, err
 		}
 
-		// suspend all non matching pods from profile
-		if profile != nil {
-			return r.suspendNotInProfile(ctx, ns, *profile, logger)
+		wasSuspended := isNamespaceSuspended(ns)
+		if err := r.patchCondition(ctx, &ns, conditionSuspended, corev1.ConditionTrue, reasonSuspended, "all pods are suspended"); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		if !wasSuspended {
+			r.notify(ctx, ns, v1beta1.NotificationEventSuspended, state.Profile, "all pods are suspended")
 		}
+
+		if err := r.reportSavings(ctx, &ns, requests); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		// verify periodically that no pods are running in the suspended namespace
+		return ctrl.Result{RequeueAfter: r.opts.ResyncInterval}, nil
 	}
 
-	return res, err
-}
+	logger.Info("make sure namespace is resumed")
 
-func matchesResumeProfile(pod corev1.Pod, profile v1beta1.ResumeProfile) bool {
-	for _, match := range profile.Spec.PodSelector {
-		selector, err := metav1.LabelSelectorAsSelector(&match)
-		if err != nil {
-			continue
+	// Overrides are applied before any pod is resumed, otherwise resumed pods would start with their original resources
+	if err := r.applyOverrides(ctx, ns, profile, logger); err != nil {
+		r.notify(ctx, ns, v1beta1.NotificationEventFailed, state.Profile, fmt.Sprintf("failed to apply workload overrides: %s", err))
+		return ctrl.Result{}, err
+	}
+
+	res, err = r.transition(ctx, &ns, profile, state, batch, logger)
+	if err != nil {
+		r.notify(ctx, ns, v1beta1.NotificationEventFailed, state.Profile, fmt.Sprintf("failed to resume namespace: %s", err))
+	}
+
+	if err != nil || !res.IsZero() {
+		return res, err
+	}
+
+	if getNamespaceCondition(ns, conditionSuspended) != nil && !state.Protected {
+		wasSuspended := isNamespaceSuspended(ns)
+		if err := r.patchCondition(ctx, &ns, conditionSuspended, corev1.ConditionFalse, reasonResumed, "namespace is resumed"); err != nil {
+			return ctrl.Result{}, err
 		}
 
-		if selector.Matches(labels.Set(pod.Labels)) {
-			return true
+		if wasSuspended {
+			r.notify(ctx, ns, v1beta1.NotificationEventResumed, state.Profile, "namespace is resumed")
 		}
 	}
 
-	return false
+	if err := r.resetSavings(ctx, &ns); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return res, err
 }
 
-func (r *NamespaceReconciler) resume(ctx context.Context, ns corev1.Namespace, profile *v1beta1.ResumeProfile, logger logr.Logger) (ctrl.Result, error) {
-	var list corev1.PodList
-	if err := r.Client.List(ctx, &list, client.InNamespace(ns.Name)); err != nil {
-		return ctrl.Result{}, err
+// getResumeProfile fetches a ResumeProfile, it is used to resolve included profiles
+func (r *NamespaceReconciler) getResumeProfile(ctx context.Context, key client.ObjectKey) (*v1beta1.ResumeProfile, error) {
+	var profile v1beta1.ResumeProfile
+	if err := r.Client.Get(ctx, key, &profile); err != nil {
+		return nil, err
 	}
 
-	for _, pod := range list.Items {
-		if ignore, ok := pod.Annotations[ignoreAnnotation]; ok && ignore == "true" {
-			continue
-		}
+	return &profile, nil
+}
 
-		if profile != nil {
-			if !matchesResumeProfile(pod, *profile) {
-				continue
-			}
+// notify sends a notification about the namespace to its notification targets
+func (r *NamespaceReconciler) notify(ctx context.Context, ns corev1.Namespace, event v1beta1.NotificationEvent, profile, message string) {
+	r.Notifier.Notify(ctx, Notification{
+		Event:     event,
+		Namespace: ns.Name,
+		Profile:   profile,
+		Message:   message,
+		Timestamp: time.Now(),
+	})
+}
+
+// refuseProtected reports if a protected namespace is requested to be suspended
+func (r *NamespaceReconciler) refuseProtected(ctx context.Context, ns *corev1.Namespace, policy *v1beta1.NamespacePausePolicy, logger logr.Logger) error {
+	if state := suspendStateFromNamespace(*ns, ProtectedNamespaces{}).withPolicy(policy); !state.Suspend && state.Profile == "" {
+		return nil
+	}
+
+	message := r.opts.Protected.reason(*ns)
+	if condition := getNamespaceCondition(*ns, conditionSuspended); condition != nil &&
+		condition.Reason == reasonProtected && condition.Message == message {
+		return nil
+	}
+
+	logger.Info("refusing to suspend protected namespace", "reason", message)
+	r.Recorder.Eventf(ns, corev1.EventTypeWarning, reasonRefused, "refusing to suspend namespace: %s", message)
+
+	return r.patchCondition(ctx, ns, conditionSuspended, corev1.ConditionFalse, reasonProtected, message)
+}
+
+func (r *NamespaceReconciler) resumePod(ctx context.Context, pod corev1.Pod, logger logr.Logger) error {
+	owned := len(pod.ObjectMeta.OwnerReferences) > 0
+
+	// Pods parked by a scheduling gate can be resumed in place
+	if hasSchedulingGate(&pod, schedulingGateName) {
+		if owned && r.opts.ResumeStrategy != ResumeStrategyInPlace {
+			return r.Client.Delete(ctx, &pod)
 		}
 
-		if pod.Status.Phase == phaseSuspended && pod.Spec.SchedulerName == schedulerName {
-			if len(pod.ObjectMeta.OwnerReferences) > 0 {
-				err := r.Client.Delete(ctx, &pod)
-				if err != nil {
-					logger.Error(err, "failed to delete pod while resuming", "pod", pod.Name)
-				}
-			} else {
-				clone := pod.DeepCopy()
+		return r.removeSchedulingGate(ctx, pod)
+	}
 
-				// We won't be able to create the object with the same resource version
-				clone.ObjectMeta.ResourceVersion = ""
+	if pod.Status.Phase != phaseSuspended || pod.Spec.SchedulerName != schedulerName {
+		return nil
+	}
 
-				// Remove assigned node to avoid scheduling
-				clone.Spec.NodeName = ""
+	// The scheduler of a pod is immutable, owned pods get recreated by their controller
+	if owned {
+		return r.Client.Delete(ctx, &pod)
+	}
 
-				// Reset status, not needed as its ignored but nice
-				clone.Status = corev1.PodStatus{}
+	clone := pod.DeepCopy()
 
-				if scheduler, ok := clone.Annotations[previousSchedulerName]; ok {
-					clone.Spec.SchedulerName = scheduler
-					delete(clone.Annotations, previousSchedulerName)
-				} else {
-					clone.Spec.SchedulerName = ""
-				}
+	// We won't be able to create the object with the same resource version
+	clone.ObjectMeta.ResourceVersion = ""
 
-				err := r.recreatePod(ctx, pod, clone)
-				if err != nil {
-					logger.Error(err, "recrete unowned pod failed", "pod", pod.Name)
-				}
-			}
+	// Remove assigned node to avoid scheduling
+	clone.Spec.NodeName = ""
+
+	// Reset status, not needed as its ignored but nice
+	clone.Status = corev1.PodStatus{}
+	delete(clone.Annotations, reasonAnnotation)
+
+	// Restore scheduler, node and further scheduling hints from before the pod was suspended
+	// The pod stays suspended if the state can not be restored, the resume is retried with the next reconciliation
+	if err := restoreSchedulingState(ctx, r.Client, clone); err != nil {
+		return fmt.Errorf("failed to restore scheduling state of pod %s: %w", pod.Name, err)
+	}
+
+	if err := r.recreatePod(ctx, pod, clone); err != nil {
+		return fmt.Errorf("recrete unowned pod failed: %w", err)
+	}
+
+	return nil
+}
+
+// removeSchedulingGate resumes a pod in place by lifting the k8s-pause scheduling gate.
+// The recorded scheduling state is dropped since the spec of an existing pod can not be changed besides lifting gates, see schedulingState.
+func (r *NamespaceReconciler) removeSchedulingGate(ctx context.Context, pod corev1.Pod) error {
+	clone := pod.DeepCopy()
+
+	var gates []corev1.PodSchedulingGate
+	for _, gate := range clone.Spec.SchedulingGates {
+		if gate.Name != schedulingGateName {
+			gates = append(gates, gate)
 		}
 	}
 
-	return ctrl.Result{}, nil
+	clone.Spec.SchedulingGates = gates
+	delete(clone.Annotations, previousSchedulerName)
+	delete(clone.Annotations, previousSchedulingState)
+	delete(clone.Annotations, reasonAnnotation)
+
+	return r.Client.Patch(ctx, clone, client.MergeFrom(&pod))
 }
 
-func (r *NamespaceReconciler) recreatePod(ctx context.Context, pod corev1.Pod, clone *corev1.Pod) error {
+func (r *NamespaceReconciler) recreatePod(ctx context.Context, pod corev1.Pod, clone *corev1.Pod, opts ...client.DeleteOption) error {
 	list := corev1.PodList{}
 	watcher, err := r.Client.Watch(ctx, &list)
 	if err != nil {
@@ -201,7 +434,7 @@ func (r *NamespaceReconciler) recreatePod(ctx context.Context, pod corev1.Pod, c
 
 	ch := watcher.ResultChan()
 
-	err = r.Client.Delete(ctx, &pod)
+	err = r.Client.Delete(ctx, &pod, opts...)
 	if err != nil {
 		return fmt.Errorf("failed to delete pod %s: %w", pod.Name, err)
 	}
@@ -224,54 +457,73 @@ func (r *NamespaceReconciler) recreatePod(ctx context.Context, pod corev1.Pod, c
 	return nil
 }
 
-func (r *NamespaceReconciler) suspend(ctx context.Context, ns corev1.Namespace, logger logr.Logger) (ctrl.Result, error) {
+// suspend parks all pods in the namespace, it returns the summed up requests of all pods which are parked.
+// Pods which fail to be suspended are retried, the failures are returned as a single error.
+func (r *NamespaceReconciler) suspend(ctx context.Context, ns corev1.Namespace, state namespaceSuspendState, batch *podBatch, logger logr.Logger) (ctrl.Result, corev1.ResourceList, error) {
 	var list corev1.PodList
 	if err := r.Client.List(ctx, &list, client.InNamespace(ns.Name)); err != nil {
-		return ctrl.Result{}, err
+		return ctrl.Result{}, nil, err
 	}
 
+	state.sortByOrder(list.Items, true)
+
+	// Any running pod is considered as drift if the namespace was already suspended before
+	var drift []string
+	var errs []error
+	alreadySuspended := isNamespaceSuspended(ns)
+
+	defer func() {
+		if len(drift) > 0 {
+			logger.Info("found running pods in suspended namespace", "pods", drift)
+			r.Recorder.Eventf(&ns, corev1.EventTypeWarning, reasonDriftDetected,
+				"found %d running pods in suspended namespace, suspending them again: %s", len(drift), strings.Join(drift, ", "))
+		}
+	}()
+
 	for _, pod := range list.Items {
-		if ignore, ok := pod.Annotations[ignoreAnnotation]; ok && ignore == "true" {
+		if state.ignores(pod) || isPodTerminated(pod) {
 			continue
 		}
 
-		if err := r.suspendPod(ctx, pod, logger); err != nil {
-			logger.Error(err, "failed to suspend pod", "pod", pod.Name)
+		// Pods of scaled down workloads are terminated by their controller
+		if isPodParked(pod) || state.scaled(pod) {
 			continue
 		}
-	}
-
-	return ctrl.Result{}, nil
-}
 
-func (r *NamespaceReconciler) suspendNotInProfile(ctx context.Context, ns corev1.Namespace, profile v1beta1.ResumeProfile, logger logr.Logger) (ctrl.Result, error) {
-	var list corev1.PodList
-	if err := r.Client.List(ctx, &list, client.InNamespace(ns.Name)); err != nil {
-		return ctrl.Result{}, err
-	}
+		if ok, err := batch.next(ctx); err != nil {
+			return ctrl.Result{}, nil, err
+		} else if !ok {
+			logger.Info("suspend batch exhausted, continue later")
+			return ctrl.Result{RequeueAfter: batchRequeueAfter}, nil, nil
+		}
 
-	for _, pod := range list.Items {
-		if matchesResumeProfile(pod, profile) {
-			continue
+		if alreadySuspended {
+			drift = append(drift, pod.Name)
 		}
 
-		if err := r.suspendPod(ctx, pod, logger); err != nil {
+		if err := r.suspendPod(ctx, pod, state, logger); err != nil {
 			logger.Error(err, "failed to suspend pod", "pod", pod.Name)
-			continue
+			batch.release()
+			errs = append(errs, fmt.Errorf("pod %s: %w", pod.Name, err))
 		}
 	}
 
-	return ctrl.Result{}, nil
+	// The namespace is only reported as suspended once every pod is suspended
+	if len(errs) > 0 {
+		return ctrl.Result{}, nil, fmt.Errorf("failed to suspend %d %s: %w", len(errs), plural(len(errs)), utilerrors.NewAggregate(errs))
+	}
+
+	return ctrl.Result{}, parkedRequests(list.Items, state), nil
 }
 
-func (r *NamespaceReconciler) suspendPod(ctx context.Context, pod corev1.Pod, logger logr.Logger) error {
-	if pod.Spec.SchedulerName == schedulerName {
+func (r *NamespaceReconciler) suspendPod(ctx context.Context, pod corev1.Pod, state namespaceSuspendState, logger logr.Logger) error {
+	if isPodParked(pod) {
 		return nil
 	}
 
 	// We assume the pod is managed by another controller if there is an existing owner ref
 	if len(pod.ObjectMeta.OwnerReferences) > 0 {
-		err := r.Client.Delete(ctx, &pod)
+		err := r.Client.Delete(ctx, &pod, state.deleteOptions()...)
 		if err != nil {
 			return err
 		}
@@ -288,16 +540,15 @@ func (r *NamespaceReconciler) suspendPod(ctx context.Context, pod corev1.Pod, lo
 		// Reset status, not needed as its ignored but nice
 		clone.Status = corev1.PodStatus{}
 
-		// Assign our own scheduler to avoid the default scheduler interfer with the workload
-		clone.Spec.SchedulerName = schedulerName
-
-		if clone.Annotations == nil {
-			clone.Annotations = make(map[string]string)
+		// Keep the scheduling state so it can be restored once the pod gets resumed
+		if err := recordSchedulingState(pod, clone); err != nil {
+			return err
 		}
 
-		clone.Annotations[previousSchedulerName] = pod.Spec.SchedulerName
+		// Assign our own scheduler or scheduling gate to avoid the default scheduler interfer with the workload
+		parkPod(clone, state.suspendMode(r.opts.SuspendMode))
 
-		err := r.recreatePod(ctx, pod, clone)
+		err := r.recreatePod(ctx, pod, clone, state.deleteOptions()...)
 		if err != nil {
 			return fmt.Errorf("recrete unowned pod `%s` failed: %w", pod.Name, err)
 		}
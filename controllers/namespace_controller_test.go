@@ -0,0 +1,429 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// newTestNamespaceReconciler creates a NamespaceReconciler backed by a fake client holding the given objects
+func newTestNamespaceReconciler(t *testing.T, opts NamespaceReconcilerOptions, objects ...client.Object) *NamespaceReconciler {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	return &NamespaceReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		Log:      logr.Discard(),
+		Scheme:   scheme,
+		Recorder: record.NewFakeRecorder(100),
+		opts:     opts,
+	}
+}
+
+func TestResumePod(t *testing.T) {
+	owner := []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "api-7d9f", UID: "uid"}}
+
+	gated := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "gated",
+			Namespace:       "staging",
+			OwnerReferences: owner,
+			Annotations:     map[string]string{previousSchedulerName: "default-scheduler", reasonAnnotation: "suspended"},
+		},
+		Spec: corev1.PodSpec{
+			SchedulingGates: []corev1.PodSchedulingGate{{Name: "other"}, {Name: schedulingGateName}},
+		},
+	}
+
+	scheduled := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "scheduled",
+			Namespace:       "staging",
+			OwnerReferences: owner,
+		},
+		Spec:   corev1.PodSpec{SchedulerName: schedulerName},
+		Status: corev1.PodStatus{Phase: phaseSuspended},
+	}
+
+	for _, test := range []struct {
+		name     string
+		strategy ResumeStrategy
+		pod      *corev1.Pod
+		deleted  bool
+	}{
+		{name: "gated pod is recreated", strategy: ResumeStrategyRecreate, pod: gated, deleted: true},
+		{name: "gated pod is resumed in place", strategy: ResumeStrategyInPlace, pod: gated, deleted: false},
+		{name: "pod parked by scheduler is recreated", strategy: ResumeStrategyRecreate, pod: scheduled, deleted: true},
+		{name: "pod parked by scheduler is recreated with in-place strategy", strategy: ResumeStrategyInPlace, pod: scheduled, deleted: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestNamespaceReconciler(t, NamespaceReconcilerOptions{ResumeStrategy: test.strategy}, test.pod.DeepCopy())
+			if err := r.resumePod(context.TODO(), *test.pod, logr.Discard()); err != nil {
+				t.Fatal(err)
+			}
+
+			var pod corev1.Pod
+			err := r.Client.Get(context.TODO(), client.ObjectKeyFromObject(test.pod), &pod)
+			if test.deleted {
+				if !errors.IsNotFound(err) {
+					t.Errorf("expected pod to be deleted, got %v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if isPodParked(pod) || len(pod.Spec.SchedulingGates) != 1 {
+				t.Errorf("expected only the k8s-pause scheduling gate to be removed, got %v", pod.Spec.SchedulingGates)
+			}
+
+			if len(pod.Annotations) != 0 {
+				t.Errorf("expected k8s-pause annotations to be removed, got %v", pod.Annotations)
+			}
+		})
+	}
+}
+
+func TestResumeGatedPodDropsSchedulingState(t *testing.T) {
+	original := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "bare", Namespace: "staging"},
+		Spec: corev1.PodSpec{
+			NodeName:    "node-a",
+			Tolerations: []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpExists}},
+		},
+	}
+
+	// suspendPod recreates bare pods parked by the gate with the recorded scheduling state
+	gated := original.DeepCopy()
+	if err := recordSchedulingState(original, gated); err != nil {
+		t.Fatal(err)
+	}
+
+	gated.Spec.NodeName = ""
+	gated.Spec.SchedulingGates = []corev1.PodSchedulingGate{{Name: schedulingGateName}}
+
+	r := newTestNamespaceReconciler(t, NamespaceReconcilerOptions{}, gated, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}})
+	if err := r.resumePod(context.TODO(), *gated, logr.Discard()); err != nil {
+		t.Fatal(err)
+	}
+
+	var pod corev1.Pod
+	if err := r.Client.Get(context.TODO(), client.ObjectKeyFromObject(gated), &pod); err != nil {
+		t.Fatal(err)
+	}
+
+	if pod.Spec.NodeName != "" || len(pod.Spec.SchedulingGates) != 0 || len(pod.Spec.Tolerations) != 1 {
+		t.Errorf("expected the pod to be resumed in place with the spec it was parked with, got %v", pod.Spec)
+	}
+
+	if _, ok := pod.Annotations[previousSchedulingState]; ok {
+		t.Errorf("expected the recorded scheduling state to be dropped, got %v", pod.Annotations)
+	}
+}
+
+func TestNamespaceRequestsForPod(t *testing.T) {
+	suspended := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "suspended", Annotations: map[string]string{suspendedAnnotation: "true"}}}
+	profiled := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "profiled", Annotations: map[string]string{profileAnnotation: "api"}}}
+	resumed := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "resumed"}}
+	protected := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "protected", Annotations: map[string]string{suspendedAnnotation: "true"}}}
+
+	r := newTestNamespaceReconciler(t, NamespaceReconcilerOptions{Protected: ProtectedNamespaces{Names: []string{"protected"}}}, suspended, profiled, resumed, protected)
+
+	pod := func(namespace string, parked bool) *corev1.Pod {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: namespace}}
+		if parked {
+			pod.Spec.SchedulerName = schedulerName
+		}
+
+		return pod
+	}
+
+	profile := func(namespace string) *v1beta1.ResumeProfile {
+		return &v1beta1.ResumeProfile{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: namespace}}
+	}
+
+	for _, test := range []struct {
+		name     string
+		requests []reconcile.Request
+		expected string
+	}{
+		{name: "pod in suspended namespace", requests: r.requestsForPod(r.Client)(pod("suspended", false)), expected: "suspended"},
+		{name: "pod in namespace with profile", requests: r.requestsForPod(r.Client)(pod("profiled", false)), expected: "profiled"},
+		{name: "pod in resumed namespace", requests: r.requestsForPod(r.Client)(pod("resumed", false))},
+		{name: "parked pod in resumed namespace", requests: r.requestsForPod(r.Client)(pod("resumed", true)), expected: "resumed"},
+		{name: "pod in protected namespace", requests: r.requestsForPod(r.Client)(pod("protected", false))},
+		{name: "profile in namespace with profile", requests: r.requestsForResumeProfile(r.Client)(profile("profiled")), expected: "profiled"},
+		{name: "profile in suspended namespace", requests: r.requestsForResumeProfile(r.Client)(profile("suspended"))},
+		{name: "profile in unknown namespace", requests: r.requestsForResumeProfile(r.Client)(profile("unknown"))},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if test.expected == "" {
+				if len(test.requests) != 0 {
+					t.Errorf("expected no requests, got %v", test.requests)
+				}
+
+				return
+			}
+
+			if len(test.requests) != 1 || test.requests[0].Name != test.expected || test.requests[0].Namespace != "" {
+				t.Errorf("expected a request for namespace %s, got %v", test.expected, test.requests)
+			}
+		})
+	}
+}
+
+func TestPodChangePredicate(t *testing.T) {
+	pod := func(labels, annotations map[string]string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Labels: labels, Annotations: annotations}}
+	}
+
+	for _, test := range []struct {
+		name     string
+		old, new *corev1.Pod
+		expected bool
+	}{
+		{name: "labels changed", old: pod(map[string]string{"app": "a"}, nil), new: pod(map[string]string{"app": "b"}, nil), expected: true},
+		{name: "ignore annotation changed", old: pod(nil, nil), new: pod(nil, map[string]string{ignoreAnnotation: "true"}), expected: true},
+		{name: "other annotation changed", old: pod(nil, nil), new: pod(nil, map[string]string{"foo": "bar"}), expected: false},
+		{name: "nothing changed", old: pod(map[string]string{"app": "a"}, nil), new: pod(map[string]string{"app": "a"}, nil), expected: false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if changed := podChangePredicate().Update(event.UpdateEvent{ObjectOld: test.old, ObjectNew: test.new}); changed != test.expected {
+				t.Errorf("expected %v, got %v", test.expected, changed)
+			}
+		})
+	}
+
+	if !podChangePredicate().Create(event.CreateEvent{Object: pod(nil, nil)}) {
+		t.Error("expected created pods to be reconciled")
+	}
+
+	if podChangePredicate().Delete(event.DeleteEvent{Object: pod(nil, nil)}) {
+		t.Error("expected deleted pods not to be reconciled")
+	}
+}
+
+// failingDeleteClient fails to delete the pods with the given names
+type failingDeleteClient struct {
+	client.WithWatch
+	names map[string]bool
+}
+
+func (c failingDeleteClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if c.names[obj.GetName()] {
+		return errors.NewInternalError(fmt.Errorf("delete of %s failed", obj.GetName()))
+	}
+
+	return c.WithWatch.Delete(ctx, obj, opts...)
+}
+
+func TestSuspendBatch(t *testing.T) {
+	owner := []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "api-7d9f", UID: "uid"}}
+	now := metav1.Now()
+
+	pod := func(name string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "staging", OwnerReferences: owner}}
+	}
+
+	terminating := pod("a-terminating")
+	terminating.DeletionTimestamp = &now
+	terminating.Finalizers = []string{"test"}
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "staging", Annotations: map[string]string{suspendedAnnotation: "true"}}}
+	r := newTestNamespaceReconciler(t, NamespaceReconcilerOptions{BatchSize: 1}, ns, terminating, pod("b-failing"), pod("c-running"))
+	r.Client = failingDeleteClient{WithWatch: r.Client, names: map[string]bool{"b-failing": true}}
+
+	res, _, err := r.suspend(context.TODO(), *ns, namespaceSuspendState{Suspend: true}, newPodBatch(nil, 1), logr.Discard())
+	if err == nil {
+		t.Error("expected the failed pod to be reported")
+	}
+
+	if !res.IsZero() {
+		t.Errorf("expected terminating and failing pods not to exhaust the batch, got %v", res)
+	}
+
+	var running corev1.Pod
+	if err := r.Client.Get(context.TODO(), client.ObjectKey{Namespace: "staging", Name: "c-running"}, &running); !errors.IsNotFound(err) {
+		t.Errorf("expected running pod to be suspended, got %v", err)
+	}
+}
+
+func TestSuspendDrift(t *testing.T) {
+	owner := []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "api-7d9f", UID: "uid"}}
+	now := metav1.Now()
+
+	pod := func(name string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "staging", OwnerReferences: owner}}
+	}
+
+	terminating := pod("terminating")
+	terminating.DeletionTimestamp = &now
+	terminating.Finalizers = []string{"test"}
+
+	parked := pod("parked")
+	parked.Spec.SchedulerName = schedulerName
+
+	for _, test := range []struct {
+		name      string
+		suspended bool
+		events    []string
+	}{
+		{name: "running pod in suspended namespace is drift", suspended: true, events: []string{
+			"Warning DriftDetected found 1 running pods in suspended namespace, suspending them again: running",
+		}},
+		{name: "running pod while suspending is no drift", suspended: false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "staging", Annotations: map[string]string{suspendedAnnotation: "true"}}}
+			if test.suspended {
+				setNamespaceCondition(ns, conditionSuspended, corev1.ConditionTrue, reasonSuspended, "all pods are suspended")
+			}
+
+			r := newTestNamespaceReconciler(t, NamespaceReconcilerOptions{}, ns, terminating.DeepCopy(), parked.DeepCopy(), pod("running"))
+			if _, _, err := r.suspend(context.TODO(), *ns, namespaceSuspendState{Suspend: true}, newPodBatch(nil, 0), logr.Discard()); err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := r.Recorder.(*record.FakeRecorder)
+			close(recorder.Events)
+
+			var events []string
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+
+			if fmt.Sprint(events) != fmt.Sprint(test.events) {
+				t.Errorf("expected events %v, got %v", test.events, events)
+			}
+		})
+	}
+}
+
+func TestReconcileSuspendFailure(t *testing.T) {
+	owner := []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "api-7d9f", UID: "uid"}}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "staging", Annotations: map[string]string{suspendedAnnotation: "true"}}}
+	r := newTestNamespaceReconciler(t, NamespaceReconcilerOptions{}, ns,
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "failing", Namespace: "staging", OwnerReferences: owner}},
+	)
+
+	c := r.Client
+	r.Client = failingDeleteClient{WithWatch: c, names: map[string]bool{"failing": true}}
+
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: "staging"}}
+	if _, err := r.Reconcile(context.TODO(), req); err == nil {
+		t.Error("expected the failed pod to be reported")
+	}
+
+	var updated corev1.Namespace
+	if err := c.Get(context.TODO(), req.NamespacedName, &updated); err != nil {
+		t.Fatal(err)
+	}
+
+	if isNamespaceSuspended(updated) {
+		t.Error("expected namespace not to be reported as suspended while a pod failed to be suspended")
+	}
+
+	r.Client = c
+	if _, err := r.Reconcile(context.TODO(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Get(context.TODO(), req.NamespacedName, &updated); err != nil {
+		t.Fatal(err)
+	}
+
+	if !isNamespaceSuspended(updated) {
+		t.Error("expected namespace to be reported as suspended once all pods are suspended")
+	}
+}
+
+func TestReconcileResumeFailureNotifies(t *testing.T) {
+	server := newNotificationServer(0)
+	defer server.Close()
+
+	owner := []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "api-7d9f", UID: "uid"}}
+	parked := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "failing", Namespace: "staging", OwnerReferences: owner},
+		Spec:       corev1.PodSpec{SchedulerName: schedulerName},
+		Status:     corev1.PodStatus{Phase: phaseSuspended},
+	}
+
+	target := &v1beta1.NotificationTarget{
+		ObjectMeta: metav1.ObjectMeta{Name: "target", Namespace: "staging"},
+		Spec: v1beta1.NotificationTargetSpec{
+			Address: server.URL,
+			Events:  []v1beta1.NotificationEvent{v1beta1.NotificationEventFailed},
+		},
+	}
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "staging"}}
+	r := newTestNamespaceReconciler(t, NamespaceReconcilerOptions{}, ns, parked, target)
+	r.Client = failingDeleteClient{WithWatch: r.Client, names: map[string]bool{"failing": true}}
+	r.Notifier = &Notifier{Client: r.Client, HTTPClient: server.Client(), Log: logr.Discard()}
+
+	req := ctrl.Request{NamespacedName: client.ObjectKey{Name: "staging"}}
+	if _, err := r.Reconcile(context.TODO(), req); err == nil {
+		t.Fatal("expected the failed pod to be reported")
+	}
+
+	// Notifications are delivered in the background
+	for i := 0; i < 100; i++ {
+		server.mu.Lock()
+		requests := len(server.requests)
+		server.mu.Unlock()
+
+		if requests > 0 {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if len(server.requests) != 1 || !strings.Contains(server.requests[0], `"event":"Failed"`) || !strings.Contains(server.requests[0], "resume pod failing") {
+		t.Errorf("expected a failed notification naming the pod, got %v", server.requests)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// conditionSuspended is the namespace condition reporting the suspend state managed by k8s-pause
+	conditionSuspended = corev1.NamespaceConditionType("Suspended")
+
+	reasonSuspended     = "Suspended"
+	reasonResumed       = "Resumed"
+	reasonDriftDetected = "DriftDetected"
+	reasonProtected     = "Protected"
+	reasonRefused       = "SuspendRefused"
+)
+
+func getNamespaceCondition(ns corev1.Namespace, conditionType corev1.NamespaceConditionType) *corev1.NamespaceCondition {
+	for i := range ns.Status.Conditions {
+		if ns.Status.Conditions[i].Type == conditionType {
+			return &ns.Status.Conditions[i]
+		}
+	}
+
+	return nil
+}
+
+// isNamespaceSuspended returns true if the namespace reports that all pods are suspended
+func isNamespaceSuspended(ns corev1.Namespace) bool {
+	condition := getNamespaceCondition(ns, conditionSuspended)
+	return condition != nil && condition.Status == corev1.ConditionTrue
+}
+
+// setNamespaceCondition adds or updates a condition, the transition time is only changed if the status changes
+func setNamespaceCondition(ns *corev1.Namespace, conditionType corev1.NamespaceConditionType, status corev1.ConditionStatus, reason, message string) {
+	condition := corev1.NamespaceCondition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: metav1.Now(),
+	}
+
+	for i, existing := range ns.Status.Conditions {
+		if existing.Type != conditionType {
+			continue
+		}
+
+		if existing.Status == status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+
+		ns.Status.Conditions[i] = condition
+		return
+	}
+
+	ns.Status.Conditions = append(ns.Status.Conditions, condition)
+}
+
+// patchCondition patches the namespace status if the given condition differs from the current one.
+// ns is updated with the patched status, this allows to patch multiple conditions in a row.
+func (r *NamespaceReconciler) patchCondition(ctx context.Context, ns *corev1.Namespace, conditionType corev1.NamespaceConditionType, status corev1.ConditionStatus, reason, message string) error {
+	if current := getNamespaceCondition(*ns, conditionType); current != nil &&
+		current.Status == status && current.Reason == reason && current.Message == message {
+		return nil
+	}
+
+	updated := ns.DeepCopy()
+	setNamespaceCondition(updated, conditionType, status, reason, message)
+	if err := r.Client.Status().Patch(ctx, updated, client.MergeFrom(ns)); err != nil {
+		return err
+	}
+
+	*ns = *updated
+	return nil
+}
@@ -0,0 +1,385 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+//+kubebuilder:rbac:groups=pause.infra.doodle.com,resources=notificationtargets,verbs=get;list;watch
+
+const (
+	// notificationAddressKey is the key of the address in the secret referenced by a NotificationTarget
+	notificationAddressKey = "address"
+
+	reasonNotificationFailed = "NotificationFailed"
+)
+
+// errAddressNotAllowed is returned if a notification is refused because of its address, it is never retried
+var errAddressNotAllowed = errors.New("address not allowed")
+
+// notificationClient is used if the Notifier has no HTTPClient.
+// It does not follow redirects and refuses to connect to loopback, link-local, multicast, unspecified and private addresses,
+// this prevents NotificationTargets from reaching the controller itself, cloud metadata endpoints or services within the cluster.
+var notificationClient = newNotificationClient(false)
+
+// privateNotificationClient is used instead of notificationClient for hosts listed in the AllowedHosts of the Notifier,
+// it connects to private addresses as well to reach receivers within the cluster or the internal network.
+var privateNotificationClient = newNotificationClient(true)
+
+func newNotificationClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: dialControl(allowPrivate),
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// dialControl refuses connections to addresses notifications must not be sent to.
+// The address is checked after name resolution, therefore hosts resolving to a refused address are refused as well.
+func dialControl(allowPrivate bool) func(network, address string, _ syscall.RawConn) error {
+	return func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+
+		ip := net.ParseIP(host)
+		if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+			return fmt.Errorf("%w: %s", errAddressNotAllowed, host)
+		}
+
+		if ip.IsPrivate() && !allowPrivate {
+			return fmt.Errorf("%w: %s is a private address, add the host to the allowed hosts to send notifications to it", errAddressNotAllowed, host)
+		}
+
+		return nil
+	}
+}
+
+// Notification describes a state transition of a namespace
+type Notification struct {
+	Event     v1beta1.NotificationEvent `json:"event"`
+	Namespace string                    `json:"namespace"`
+	Profile   string                    `json:"profile,omitempty"`
+	Message   string                    `json:"message"`
+	Timestamp time.Time                 `json:"timestamp"`
+}
+
+// Notifier delivers notifications to the NotificationTargets of a namespace
+type Notifier struct {
+	Client     client.Reader
+	HTTPClient *http.Client
+	Log        logr.Logger
+	Recorder   record.EventRecorder
+
+	// Backoff is the delay before the first retry, it doubles with each retry
+	Backoff time.Duration
+
+	// AllowedHosts restricts the hosts notifications are sent to, a leading "*." matches any subdomain.
+	// All hosts are allowed if empty, however only listed hosts may resolve to a private address.
+	AllowedHosts []string
+
+	mu sync.Mutex
+	// failures holds the last failure per namespace to avoid sending the same failure on each reconciliation
+	failures map[string]string
+}
+
+// Notify sends a notification to all NotificationTargets in the namespace which subscribed to the event.
+// Notifications are delivered in the background, failed deliveries are logged and recorded as event on the target.
+func (n *Notifier) Notify(ctx context.Context, notification Notification) {
+	if n == nil || !n.dedup(notification) {
+		return
+	}
+
+	var targets v1beta1.NotificationTargetList
+	if err := n.Client.List(ctx, &targets, client.InNamespace(notification.Namespace)); err != nil {
+		n.Log.Error(err, "failed to list notification targets", "namespace", notification.Namespace)
+		return
+	}
+
+	for _, target := range targets.Items {
+		if !subscribed(target, notification.Event) {
+			continue
+		}
+
+		target := target
+		go func() {
+			if err := n.send(context.Background(), target, notification); err != nil {
+				n.Log.Error(err, "failed to send notification", "namespace", target.Namespace, "target", target.Name)
+				if n.Recorder != nil {
+					n.Recorder.Eventf(&target, corev1.EventTypeWarning, reasonNotificationFailed, "failed to send %s notification: %s", notification.Event, err)
+				}
+			}
+		}()
+	}
+}
+
+// dedup returns false if the notification is a failure which has already been sent for the namespace.
+// Any other notification resets the failure state of the namespace.
+func (n *Notifier) dedup(notification Notification) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.failures == nil {
+		n.failures = make(map[string]string)
+	}
+
+	if notification.Event != v1beta1.NotificationEventFailed {
+		delete(n.failures, notification.Namespace)
+		return true
+	}
+
+	if n.failures[notification.Namespace] == notification.Message {
+		return false
+	}
+
+	n.failures[notification.Namespace] = notification.Message
+	return true
+}
+
+func subscribed(target v1beta1.NotificationTarget, event v1beta1.NotificationEvent) bool {
+	if len(target.Spec.Events) == 0 {
+		return true
+	}
+
+	for _, e := range target.Spec.Events {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// send delivers a notification to a target, it is retried with an exponential backoff on network errors, 429 and 5xx responses
+func (n *Notifier) send(ctx context.Context, target v1beta1.NotificationTarget, notification Notification) error {
+	address, err := n.address(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	u, err := n.validateAddress(address)
+	if err != nil {
+		return err
+	}
+
+	payload, err := renderNotification(target, notification)
+	if err != nil {
+		return err
+	}
+
+	retries := 3
+	if target.Spec.Retries != nil {
+		retries = int(*target.Spec.Retries)
+	}
+
+	httpClient := n.HTTPClient
+	if httpClient == nil {
+		httpClient = notificationClient
+		if n.listedHost(u.Hostname()) {
+			httpClient = privateNotificationClient
+		}
+	}
+
+	backoff := n.Backoff
+	if backoff == 0 {
+		backoff = time.Second
+	}
+
+	for attempt := 0; ; attempt++ {
+		err = post(ctx, httpClient, address, target.Spec.Headers, payload)
+		if err == nil {
+			notificationsTotal.WithLabelValues("success").Inc()
+			return nil
+		}
+
+		if _, retryable := err.(retryableError); !retryable || attempt >= retries {
+			notificationsTotal.WithLabelValues("failure").Inc()
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
+
+func (n *Notifier) address(ctx context.Context, target v1beta1.NotificationTarget) (string, error) {
+	if target.Spec.SecretRef == nil {
+		if target.Spec.Address == "" {
+			return "", fmt.Errorf("neither address nor secretRef is set")
+		}
+
+		return target.Spec.Address, nil
+	}
+
+	var secret corev1.Secret
+	if err := n.Client.Get(ctx, client.ObjectKey{Name: target.Spec.SecretRef.Name, Namespace: target.Namespace}, &secret); err != nil {
+		return "", fmt.Errorf("failed to get secret %s: %w", target.Spec.SecretRef.Name, err)
+	}
+
+	address, ok := secret.Data[notificationAddressKey]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %s", secret.Name, notificationAddressKey)
+	}
+
+	return string(address), nil
+}
+
+// validateAddress makes sure notifications are only posted to http(s) urls of allowed hosts
+func (n *Notifier) validateAddress(address string) (*url.URL, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%w: scheme %q is not supported, use http or https", errAddressNotAllowed, u.Scheme)
+	}
+
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("%w: address has no host", errAddressNotAllowed)
+	}
+
+	if len(n.AllowedHosts) > 0 && !n.listedHost(u.Hostname()) {
+		return nil, fmt.Errorf("%w: host %s is not allowed", errAddressNotAllowed, strings.ToLower(u.Hostname()))
+	}
+
+	return u, nil
+}
+
+// listedHost returns true if the host matches an entry of AllowedHosts
+func (n *Notifier) listedHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range n.AllowedHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || (strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:])) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// renderNotification creates the payload for the target
+func renderNotification(target v1beta1.NotificationTarget, notification Notification) ([]byte, error) {
+	if target.Spec.Template != "" {
+		tmpl, err := template.New(target.Name).Funcs(template.FuncMap{
+			"json": func(v interface{}) (string, error) {
+				b, err := json.Marshal(v)
+				return string(b), err
+			},
+		}).Parse(target.Spec.Template)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template: %w", err)
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, notification); err != nil {
+			return nil, fmt.Errorf("failed to render template: %w", err)
+		}
+
+		if !json.Valid(buf.Bytes()) {
+			return nil, fmt.Errorf("template did not render valid json")
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	if target.Spec.Format == v1beta1.NotificationFormatSlack {
+		text := fmt.Sprintf("*%s*: namespace `%s`: %s", notification.Event, notification.Namespace, notification.Message)
+		if notification.Profile != "" {
+			text = fmt.Sprintf("%s (profile `%s`)", text, notification.Profile)
+		}
+
+		return json.Marshal(map[string]string{"text": text})
+	}
+
+	return json.Marshal(notification)
+}
+
+// retryableError is returned for failed deliveries which may succeed if retried
+type retryableError struct {
+	error
+}
+
+func post(ctx context.Context, httpClient *http.Client, address string, headers map[string]string, payload []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, address, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	res, err := httpClient.Do(req)
+	if errors.Is(err, errAddressNotAllowed) {
+		return err
+	}
+
+	if err != nil {
+		return retryableError{err}
+	}
+
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return retryableError{fmt.Errorf("unexpected status code %d", res.StatusCode)}
+	case res.StatusCode >= 300:
+		return fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,281 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// notificationServer is a local webhook receiver which fails the first requests
+type notificationServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	failures int
+	requests []string
+}
+
+func newNotificationServer(failures int) *notificationServer {
+	s := &notificationServer{failures: failures}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.failures > 0 {
+			s.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		b, _ := io.ReadAll(r.Body)
+		s.requests = append(s.requests, r.Header.Get("X-Team")+" "+string(b))
+	}))
+
+	return s
+}
+
+func TestNotifierSend(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	notification := Notification{
+		Event:     v1beta1.NotificationEventSuspended,
+		Namespace: "staging",
+		Message:   "all pods are suspended",
+		Timestamp: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	one := int32(1)
+
+	for _, test := range []struct {
+		name     string
+		failures int
+		spec     v1beta1.NotificationTargetSpec
+		secret   bool
+		err      bool
+		expected []string
+	}{
+		{
+			name:     "json",
+			expected: []string{` {"event":"Suspended","namespace":"staging","message":"all pods are suspended","timestamp":"2023-01-01T00:00:00Z"}`},
+		},
+		{
+			name:     "slack with address from secret",
+			spec:     v1beta1.NotificationTargetSpec{Format: v1beta1.NotificationFormatSlack},
+			secret:   true,
+			expected: []string{" {\"text\":\"*Suspended*: namespace `staging`: all pods are suspended\"}"},
+		},
+		{
+			name: "template with headers",
+			spec: v1beta1.NotificationTargetSpec{
+				Template: `{"summary":{{ json .Message }},"env":{{ json .Namespace }}}`,
+				Headers:  map[string]string{"X-Team": "platform"},
+			},
+			expected: []string{`platform {"summary":"all pods are suspended","env":"staging"}`},
+		},
+		{
+			name:     "invalid json from template",
+			spec:     v1beta1.NotificationTargetSpec{Template: `{{ .Message }}`},
+			err:      true,
+			expected: nil,
+		},
+		{
+			name:     "retried until delivered",
+			failures: 2,
+			expected: []string{` {"event":"Suspended","namespace":"staging","message":"all pods are suspended","timestamp":"2023-01-01T00:00:00Z"}`},
+		},
+		{
+			name:     "retries exhausted",
+			failures: 2,
+			spec:     v1beta1.NotificationTargetSpec{Retries: &one},
+			err:      true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server := newNotificationServer(test.failures)
+			defer server.Close()
+
+			target := v1beta1.NotificationTarget{
+				ObjectMeta: metav1.ObjectMeta{Name: "target", Namespace: "staging"},
+				Spec:       test.spec,
+			}
+
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "webhook", Namespace: "staging"},
+				Data:       map[string][]byte{notificationAddressKey: []byte(server.URL)},
+			}
+
+			if test.secret {
+				target.Spec.SecretRef = &corev1.LocalObjectReference{Name: secret.Name}
+			} else {
+				target.Spec.Address = server.URL
+			}
+
+			notifier := &Notifier{
+				Client:     fake.NewClientBuilder().WithScheme(scheme).WithObjects(secret).Build(),
+				HTTPClient: server.Client(),
+				Backoff:    time.Millisecond,
+			}
+
+			err := notifier.send(context.TODO(), target, notification)
+			if test.err != (err != nil) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+
+			if len(server.requests) != len(test.expected) {
+				t.Fatalf("expected requests %v, got %v", test.expected, server.requests)
+			}
+
+			for i := range test.expected {
+				if server.requests[i] != test.expected[i] {
+					t.Errorf("expected request %s, got %s", test.expected[i], server.requests[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNotifierValidateAddress(t *testing.T) {
+	for _, test := range []struct {
+		address string
+		allowed []string
+		err     bool
+	}{
+		{address: "https://hooks.slack.com/services/abc"},
+		{address: "http://receiver.monitoring.svc:8080/hook"},
+		{address: "file:///etc/passwd", err: true},
+		{address: "gopher://example.com", err: true},
+		{address: "https:///hook", err: true},
+		{address: "https://hooks.slack.com/services/abc", allowed: []string{"hooks.slack.com"}},
+		{address: "https://HOOKS.slack.com/services/abc", allowed: []string{"hooks.slack.com"}},
+		{address: "https://chat.example.com/hook", allowed: []string{"*.example.com"}},
+		{address: "https://example.com/hook", allowed: []string{"*.example.com"}, err: true},
+		{address: "https://example.com.evil.io/hook", allowed: []string{"*.example.com"}, err: true},
+		{address: "http://169.254.169.254/latest/meta-data", allowed: []string{"hooks.slack.com"}, err: true},
+	} {
+		t.Run(test.address, func(t *testing.T) {
+			notifier := &Notifier{AllowedHosts: test.allowed}
+			_, err := notifier.validateAddress(test.address)
+			if test.err != (err != nil) {
+				t.Errorf("expected error %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestNotifierRestrictedAddress(t *testing.T) {
+	server := newNotificationServer(0)
+	defer server.Close()
+
+	target := v1beta1.NotificationTarget{
+		ObjectMeta: metav1.ObjectMeta{Name: "target", Namespace: "staging"},
+		Spec:       v1beta1.NotificationTargetSpec{Address: server.URL},
+	}
+
+	// The default client refuses to connect to the loopback address of the local server
+	notifier := &Notifier{Backoff: time.Millisecond}
+	err := notifier.send(context.TODO(), target, Notification{Event: v1beta1.NotificationEventSuspended, Namespace: "staging"})
+	if !errors.Is(err, errAddressNotAllowed) {
+		t.Fatalf("expected address not to be allowed, got %v", err)
+	}
+
+	if len(server.requests) != 0 {
+		t.Errorf("expected no request, got %v", server.requests)
+	}
+}
+
+func TestNotifierPrivateAddress(t *testing.T) {
+	for _, test := range []struct {
+		address      string
+		allowPrivate bool
+		err          bool
+	}{
+		{address: "203.0.113.10:443"},
+		{address: "10.96.0.10:8080", err: true},
+		{address: "172.16.4.2:8080", err: true},
+		{address: "192.168.1.1:80", err: true},
+		{address: "[fd00::1]:80", err: true},
+		{address: "10.96.0.10:8080", allowPrivate: true},
+		{address: "[fd00::1]:80", allowPrivate: true},
+		{address: "127.0.0.1:80", allowPrivate: true, err: true},
+		{address: "169.254.169.254:80", allowPrivate: true, err: true},
+	} {
+		t.Run(fmt.Sprintf("%s/%v", test.address, test.allowPrivate), func(t *testing.T) {
+			err := dialControl(test.allowPrivate)("tcp", test.address, nil)
+			if test.err != errors.Is(err, errAddressNotAllowed) {
+				t.Errorf("expected error %v, got %v", test.err, err)
+			}
+		})
+	}
+
+	// Private addresses are only reachable for hosts which are listed explicitly
+	for _, test := range []struct {
+		allowed []string
+		listed  bool
+	}{
+		{},
+		{allowed: []string{"hooks.slack.com"}},
+		{allowed: []string{"receiver.monitoring.svc"}, listed: true},
+		{allowed: []string{"*.svc"}, listed: true},
+	} {
+		notifier := &Notifier{AllowedHosts: test.allowed}
+		if listed := notifier.listedHost("Receiver.monitoring.svc"); listed != test.listed {
+			t.Errorf("expected listed to be %v for %v, got %v", test.listed, test.allowed, listed)
+		}
+	}
+}
+
+func TestNotifierDedup(t *testing.T) {
+	notifier := &Notifier{}
+	failure := Notification{Event: v1beta1.NotificationEventFailed, Namespace: "staging", Message: "failed"}
+
+	if !notifier.dedup(failure) {
+		t.Error("expected first failure to be sent")
+	}
+
+	if notifier.dedup(failure) {
+		t.Error("expected repeated failure not to be sent")
+	}
+
+	if !notifier.dedup(Notification{Event: v1beta1.NotificationEventSuspended, Namespace: "staging"}) {
+		t.Error("expected transition to be sent")
+	}
+
+	if !notifier.dedup(failure) {
+		t.Error("expected failure after transition to be sent")
+	}
+}
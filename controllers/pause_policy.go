@@ -0,0 +1,206 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+//+kubebuilder:rbac:groups=pause.infra.doodle.com,resources=namespacepausepolicies,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=pause.infra.doodle.com,resources=namespacepausepolicies/status,verbs=get;update;patch
+
+const (
+	reasonPolicyActive   = "Active"
+	reasonPolicyConflict = "Conflict"
+
+	// scaleDownOverride is recorded as override of workloads scaled to zero by the scale strategy
+	scaleDownOverride = "k8s-pause/suspend"
+)
+
+// activePausePolicy returns the policy which takes effect for a namespace, if there are multiple policies the oldest one wins
+func activePausePolicy(policies []v1beta1.NamespacePausePolicy) *v1beta1.NamespacePausePolicy {
+	if len(policies) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(policies, func(i, j int) bool {
+		a, b := policies[i].CreationTimestamp, policies[j].CreationTimestamp
+		if !a.Equal(&b) {
+			return a.Before(&b)
+		}
+
+		return policies[i].Name < policies[j].Name
+	})
+
+	return &policies[0]
+}
+
+// suspendStateFor reads the suspend state of a namespace, a NamespacePausePolicy takes precedence over the namespace annotations.
+// All policies of the namespace are returned as well, the active policy is the first one.
+func suspendStateFor(ctx context.Context, c client.Reader, ns corev1.Namespace, protected ProtectedNamespaces) (namespaceSuspendState, []v1beta1.NamespacePausePolicy, error) {
+	var list v1beta1.NamespacePausePolicyList
+	if err := c.List(ctx, &list, client.InNamespace(ns.Name)); err != nil {
+		return namespaceSuspendState{}, nil, fmt.Errorf("failed to list namespace pause policies: %w", err)
+	}
+
+	policy := activePausePolicy(list.Items)
+	return suspendStateFromNamespace(ns, protected).withPolicy(policy), list.Items, nil
+}
+
+// withPolicy replaces the state read from the annotations with the state defined by the policy.
+// Protected namespaces stay protected regardless of the policy.
+func (s namespaceSuspendState) withPolicy(policy *v1beta1.NamespacePausePolicy) namespaceSuspendState {
+	if policy == nil || s.Protected {
+		return s
+	}
+
+	return namespaceSuspendState{
+		Suspend:            policy.Spec.Suspend,
+		Profile:            strings.Join(profileNames(strings.Join(policy.Spec.Profiles, ",")), ","),
+		Policy:             policy.Name,
+		Strategy:           policy.Spec.Strategy,
+		Ordering:           policy.Spec.Ordering,
+		GracePeriodSeconds: policy.Spec.GracePeriodSeconds,
+		Exclusions:         policy.Spec.Exclusions,
+	}
+}
+
+// ignores returns true if the pod is excluded by the ignore annotation or by the exclusions of the policy
+func (s namespaceSuspendState) ignores(pod corev1.Pod) bool {
+	return isPodIgnored(pod) || matchesLabelSelectors(pod, s.Exclusions)
+}
+
+// suspendMode returns how pods are parked, the gate strategy always uses the scheduling gate
+func (s namespaceSuspendState) suspendMode(mode SuspendMode) SuspendMode {
+	if s.Strategy == v1beta1.PauseStrategyGate {
+		return SuspendModeSchedulingGate
+	}
+
+	return mode
+}
+
+// scaled returns true if the pod belongs to a workload which is scaled to zero instead of having its pods suspended
+func (s namespaceSuspendState) scaled(pod corev1.Pod) bool {
+	if s.Strategy != v1beta1.PauseStrategyScale {
+		return false
+	}
+
+	kind, _ := podWorkload(pod)
+	return kind == "Deployment" || kind == "StatefulSet"
+}
+
+// orderGroup returns the index of the first ordering group matching the pod, pods not matched by any group are in the last group
+func (s namespaceSuspendState) orderGroup(pod corev1.Pod) int {
+	for i, selector := range s.Ordering {
+		if matchesLabelSelectors(pod, []metav1.LabelSelector{selector}) {
+			return i
+		}
+	}
+
+	return len(s.Ordering)
+}
+
+// deleteOptions returns the options used to delete pods while suspending
+func (s namespaceSuspendState) deleteOptions() []client.DeleteOption {
+	if s.GracePeriodSeconds == nil {
+		return nil
+	}
+
+	return []client.DeleteOption{client.GracePeriodSeconds(*s.GracePeriodSeconds)}
+}
+
+// sortByOrder orders pods by their ordering group, in reverse if the pods are suspended
+func (s namespaceSuspendState) sortByOrder(pods []corev1.Pod, reverse bool) {
+	if len(s.Ordering) == 0 {
+		return
+	}
+
+	sort.SliceStable(pods, func(i, j int) bool {
+		if reverse {
+			return s.orderGroup(pods[i]) > s.orderGroup(pods[j])
+		}
+
+		return s.orderGroup(pods[i]) < s.orderGroup(pods[j])
+	})
+}
+
+// reportPausePolicies reports on each policy of the namespace whether it takes effect
+func (r *NamespaceReconciler) reportPausePolicies(ctx context.Context, ns corev1.Namespace, policies []v1beta1.NamespacePausePolicy, state namespaceSuspendState) error {
+	for _, policy := range policies {
+		condition := metav1.Condition{
+			Type:               conditionReady,
+			Status:             metav1.ConditionTrue,
+			Reason:             reasonPolicyActive,
+			Message:            "policy defines the suspend state of the namespace",
+			ObservedGeneration: policy.Generation,
+		}
+
+		switch {
+		case state.Protected:
+			condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, reasonProtected, r.opts.Protected.reason(ns)
+		case policy.Name != state.Policy:
+			condition.Status, condition.Reason = metav1.ConditionFalse, reasonPolicyConflict
+			condition.Message = fmt.Sprintf("policy %s already defines the suspend state of the namespace", state.Policy)
+		}
+
+		updated := policy.DeepCopy()
+		updated.Status.ObservedGeneration = policy.Generation
+		meta.SetStatusCondition(&updated.Status.Conditions, condition)
+
+		if equality.Semantic.DeepEqual(policy.Status, updated.Status) {
+			continue
+		}
+
+		if err := r.Client.Status().Patch(ctx, updated, client.MergeFrom(&policy)); err != nil {
+			return fmt.Errorf("failed to update status of namespace pause policy %s: %w", policy.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// scaleDown scales all Deployments and StatefulSets of the namespace to zero, the replicas are restored by applyOverrides once the namespace is resumed.
+// Workloads whose pods are ignored are left alone, a workload which became ignored while scaled down is scaled up again.
+// Ordering does not apply, all workloads are scaled down at once.
+func (r *NamespaceReconciler) scaleDown(ctx context.Context, ns corev1.Namespace, state namespaceSuspendState, logger logr.Logger) error {
+	zero := int32(0)
+	override := v1beta1.WorkloadOverride{Replicas: &zero}
+
+	return r.overrideWorkloads(ctx, ns, func(workload client.Object) (string, *v1beta1.WorkloadOverride, error) {
+		_, _, template := workloadSpec(workload)
+		if !state.ignores(corev1.Pod{ObjectMeta: template.ObjectMeta, Spec: template.Spec}) {
+			return scaleDownOverride, &override, nil
+		}
+
+		if workload.GetAnnotations()[overrideAnnotation] == scaleDownOverride {
+			return "", nil, nil
+		}
+
+		return "", nil, errSkipWorkload
+	}, logger)
+}
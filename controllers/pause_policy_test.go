@@ -0,0 +1,259 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestActivePausePolicy(t *testing.T) {
+	now := time.Now()
+	policy := func(name string, age time.Duration) v1beta1.NamespacePausePolicy {
+		return v1beta1.NamespacePausePolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(now.Add(-age))},
+		}
+	}
+
+	if activePausePolicy(nil) != nil {
+		t.Error("expected no active policy without policies")
+	}
+
+	policies := []v1beta1.NamespacePausePolicy{
+		policy("new", time.Minute),
+		policy("old-b", time.Hour),
+		policy("old-a", time.Hour),
+	}
+
+	if active := activePausePolicy(policies); active.Name != "old-a" {
+		t.Errorf("expected the oldest policy old-a to be active, got %s", active.Name)
+	}
+}
+
+func TestSuspendStateWithPolicy(t *testing.T) {
+	ns := corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "staging",
+			Annotations: map[string]string{
+				suspendedAnnotation: "true",
+				profileAnnotation:   "all",
+			},
+		},
+	}
+
+	grace := int64(5)
+	policy := &v1beta1.NamespacePausePolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec: v1beta1.NamespacePausePolicySpec{
+			Profiles:           []string{"api", " worker", "api"},
+			Strategy:           v1beta1.PauseStrategyGate,
+			Ordering:           []metav1.LabelSelector{{MatchLabels: map[string]string{"tier": "db"}}},
+			GracePeriodSeconds: &grace,
+			Exclusions:         []metav1.LabelSelector{{MatchLabels: map[string]string{"app": "monitoring"}}},
+		},
+	}
+
+	state := suspendStateFromNamespace(ns, ProtectedNamespaces{}).withPolicy(policy)
+	if state.Suspend || state.Profile != "api,worker" || state.Policy != "default" {
+		t.Errorf("expected the policy to take precedence over the annotations, got %+v", state)
+	}
+
+	if mode := state.suspendMode(SuspendModeScheduler); mode != SuspendModeSchedulingGate {
+		t.Errorf("expected the gate strategy to park pods using a scheduling gate, got %s", mode)
+	}
+
+	if len(state.deleteOptions()) != 1 {
+		t.Errorf("expected the grace period to be applied on delete")
+	}
+
+	pod := func(labels map[string]string) corev1.Pod {
+		return corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: labels}}
+	}
+
+	if !state.ignores(pod(map[string]string{"app": "monitoring"})) || state.ignores(pod(map[string]string{"app": "api"})) {
+		t.Error("expected only pods matched by the exclusions to be ignored")
+	}
+
+	if group := state.orderGroup(pod(map[string]string{"tier": "db"})); group != 0 {
+		t.Errorf("expected db pods in the first ordering group, got %d", group)
+	}
+
+	if group := state.orderGroup(pod(nil)); group != 1 {
+		t.Errorf("expected unmatched pods in the last ordering group, got %d", group)
+	}
+
+	protected := ProtectedNamespaces{Names: []string{"staging"}}
+	if state := suspendStateFromNamespace(ns, protected).withPolicy(policy); !state.Protected || state.Policy != "" {
+		t.Errorf("expected protected namespaces to ignore the policy, got %+v", state)
+	}
+}
+
+func TestPlanTransitionOrdering(t *testing.T) {
+	pod := func(name, tier string) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"tier": tier}},
+			Spec:       corev1.PodSpec{SchedulerName: schedulerName},
+		}
+	}
+
+	state := namespaceSuspendState{
+		Ordering: []metav1.LabelSelector{
+			{MatchLabels: map[string]string{"tier": "db"}},
+			{MatchLabels: map[string]string{"tier": "backend"}},
+		},
+	}
+
+	pods := []corev1.Pod{pod("web", "frontend"), pod("api", "backend"), pod("postgres", "db")}
+	plan := planTransition(pods, nil, state)
+	if names := podNames(plan.resume); names != "3 pods (postgres, api, web)" {
+		t.Errorf("expected pods to be resumed in order, got %s", names)
+	}
+
+	if previousGroupsReady(pods, nil, state, 1) {
+		t.Error("expected the backend group to wait for the parked db pod")
+	}
+
+	ready := pod("postgres", "db")
+	ready.Spec.SchedulerName = ""
+	ready.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	if !previousGroupsReady([]corev1.Pod{ready, pod("api", "backend")}, nil, state, 1) {
+		t.Error("expected the backend group to be resumed once the db pod is ready")
+	}
+}
+
+func TestScaleDown(t *testing.T) {
+	three := int32(3)
+	deployment := func(name string, labels, annotations map[string]string) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "staging"},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &three,
+				Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: labels, Annotations: annotations}},
+			},
+		}
+	}
+
+	// previously scaled down before the exclusion was added
+	excludedLater := deployment("monitoring", map[string]string{"app": "monitoring"}, nil)
+	excludedLater.Spec.Replicas = new(int32)
+	excludedLater.Annotations = map[string]string{
+		overrideAnnotation:          scaleDownOverride,
+		originalReplicasAnnotation:  "3",
+		originalResourcesAnnotation: "{}",
+	}
+
+	// overridden by a profile which is left alone while the workload is excluded
+	overridden := deployment("metrics", map[string]string{"app": "monitoring"}, nil)
+	overridden.Annotations = map[string]string{
+		overrideAnnotation:          "reduced",
+		originalReplicasAnnotation:  "5",
+		originalResourcesAnnotation: "{}",
+	}
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "staging"}}
+	r := newTestNamespaceReconciler(t, NamespaceReconcilerOptions{}, ns,
+		deployment("api", map[string]string{"app": "api"}, nil),
+		deployment("debug", nil, map[string]string{ignoreAnnotation: "true"}),
+		excludedLater,
+		overridden,
+	)
+
+	state := namespaceSuspendState{
+		Suspend:    true,
+		Strategy:   v1beta1.PauseStrategyScale,
+		Exclusions: []metav1.LabelSelector{{MatchLabels: map[string]string{"app": "monitoring"}}},
+	}
+
+	if err := r.scaleDown(context.TODO(), *ns, state, logr.Discard()); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]int32{"api": 0, "debug": 3, "monitoring": 3, "metrics": 3} {
+		var updated appsv1.Deployment
+		if err := r.Client.Get(context.TODO(), client.ObjectKey{Namespace: "staging", Name: name}, &updated); err != nil {
+			t.Fatal(err)
+		}
+
+		if updated.Spec.Replicas == nil || *updated.Spec.Replicas != expected {
+			t.Errorf("expected deployment %s to have %d replicas, got %v", name, expected, updated.Spec.Replicas)
+		}
+
+		if name == "metrics" && updated.Annotations[overrideAnnotation] != "reduced" {
+			t.Errorf("expected override of excluded deployment %s to be kept, got %v", name, updated.Annotations)
+		}
+	}
+}
+
+func TestReportPausePolicies(t *testing.T) {
+	now := time.Now()
+	policy := func(name string, age time.Duration) *v1beta1.NamespacePausePolicy {
+		return &v1beta1.NamespacePausePolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "staging", CreationTimestamp: metav1.NewTime(now.Add(-age))},
+		}
+	}
+
+	for _, test := range []struct {
+		name      string
+		protected ProtectedNamespaces
+		reasons   map[string]string
+	}{
+		{
+			name:    "oldest policy is active",
+			reasons: map[string]string{"old": reasonPolicyActive, "new": reasonPolicyConflict},
+		},
+		{
+			name:      "protected namespace",
+			protected: ProtectedNamespaces{Names: []string{"staging"}},
+			reasons:   map[string]string{"old": reasonProtected, "new": reasonProtected},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "staging"}}
+			r := newTestNamespaceReconciler(t, NamespaceReconcilerOptions{Protected: test.protected}, ns, policy("old", time.Hour), policy("new", time.Minute))
+
+			state, policies, err := suspendStateFor(context.TODO(), r.Client, *ns, test.protected)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := r.reportPausePolicies(context.TODO(), *ns, policies, state); err != nil {
+				t.Fatal(err)
+			}
+
+			for name, reason := range test.reasons {
+				var updated v1beta1.NamespacePausePolicy
+				if err := r.Client.Get(context.TODO(), client.ObjectKey{Namespace: "staging", Name: name}, &updated); err != nil {
+					t.Fatal(err)
+				}
+
+				condition := meta.FindStatusCondition(updated.Status.Conditions, conditionReady)
+				if condition == nil || condition.Reason != reason {
+					t.Errorf("expected policy %s to report reason %s, got %v", name, reason, condition)
+				}
+			}
+		})
+	}
+}
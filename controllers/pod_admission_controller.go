@@ -2,12 +2,17 @@ package controllers
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"net/http"
+	"sort"
+	"strings"
 
 	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
+	"gomodules.xyz/jsonpatch/v2"
 	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -19,12 +24,43 @@ const (
 	profileAnnotation   = "k8s-pause/profile"
 	suspendedAnnotation = "k8s-pause/suspend"
 	schedulerName       = "k8s-pause"
+	schedulingGateName  = "k8s-pause.infra.doodle.com/suspended"
+	reasonAnnotation    = "k8s-pause/reason"
+)
+
+// SuspendMode defines how pods are prevented from being scheduled
+type SuspendMode string
+
+const (
+	// SuspendModeScheduler assigns the non existing k8s-pause scheduler to pods
+	SuspendModeScheduler SuspendMode = "scheduler"
+
+	// SuspendModeSchedulingGate adds the k8s-pause scheduling gate to pods, this requires Kubernetes 1.27+
+	SuspendModeSchedulingGate SuspendMode = "gate"
+)
+
+// WebhookErrorPolicy defines how pods are admitted if their suspend state can not be determined
+type WebhookErrorPolicy string
+
+const (
+	// WebhookErrorPolicyAllow admits the pod unchanged
+	WebhookErrorPolicyAllow WebhookErrorPolicy = "allow"
+
+	// WebhookErrorPolicyDeny rejects the pod
+	WebhookErrorPolicyDeny WebhookErrorPolicy = "deny"
+
+	// WebhookErrorPolicySuspend admits the pod but prevents it from being scheduled
+	WebhookErrorPolicySuspend WebhookErrorPolicy = "suspend"
 )
 
 // podAnnotator annotates Pods
 type Scheduler struct {
-	Client  client.Client
-	decoder *admission.Decoder
+	Client    client.Client
+	Mode      SuspendMode
+	OnError   WebhookErrorPolicy
+	Protected ProtectedNamespaces
+	State     *SuspendStateCache
+	decoder   *admission.Decoder
 }
 
 // podAnnotator adds an annotation to every incoming pods.
@@ -36,54 +72,254 @@ func (a *Scheduler) Handle(ctx context.Context, req admission.Request) admission
 		return admission.Errored(http.StatusBadRequest, err)
 	}
 
-	var ns corev1.Namespace
-	err = a.Client.Get(ctx, types.NamespacedName{
-		Name: req.Namespace,
-	}, &ns)
+	// Pods can only be parked on creation, the scheduler of a pod is immutable and scheduling gates can not be added to existing pods
+	switch req.Operation {
+	case admissionv1.Create:
+	case admissionv1.Update:
+		return a.handleUpdate(ctx, req, pod)
+	default:
+		return admission.Allowed("")
+	}
 
+	reason, mode, err := a.suspendReason(ctx, req.Namespace, *pod)
 	if err != nil {
+		return a.handleError(pod, err)
+	}
+
+	if reason == "" {
+		return admission.Response{
+			AdmissionResponse: admissionv1.AdmissionResponse{
+				Allowed: true,
+			},
+		}
+	}
+
+	return a.park(pod, reason, mode).WithWarnings(fmt.Sprintf("%s; pod will not be scheduled", reason))
+}
+
+// handleUpdate validates updates of existing pods.
+// The scheduler of a pod is immutable and scheduling gates can only be removed, therefore updates are never mutated.
+// However the previous scheduler annotation is protected and pods which must stay suspended can not be resumed manually.
+func (a *Scheduler) handleUpdate(ctx context.Context, req admission.Request, pod *corev1.Pod) admission.Response {
+	old := &corev1.Pod{}
+	if err := a.decoder.DecodeRaw(req.OldObject, old); err != nil {
 		return admission.Errored(http.StatusBadRequest, err)
 	}
 
-	var suspend bool
-	if suspended, ok := ns.Annotations[suspendedAnnotation]; ok {
-		if suspended == "true" {
-			suspend = true
+	previous, hadPrevious := old.Annotations[previousSchedulerName]
+	current, hasCurrent := pod.Annotations[previousSchedulerName]
+
+	// The annotation may only be removed together with the parking of the pod
+	if hadPrevious != hasCurrent || previous != current {
+		if !hadPrevious || hasCurrent || isPodParked(*pod) {
+			return admission.Denied(fmt.Sprintf("annotation %s is managed by k8s-pause", previousSchedulerName))
+		}
+	}
+
+	if !isPodParked(*old) || isPodParked(*pod) {
+		return admission.Allowed("")
+	}
+
+	reason, _, err := a.suspendReason(ctx, req.Namespace, *pod)
+	if err != nil {
+		switch a.OnError {
+		case WebhookErrorPolicyAllow:
+			webhookErrorsTotal.WithLabelValues(string(WebhookErrorPolicyAllow)).Inc()
+			return admission.Allowed("").WithWarnings(
+				fmt.Sprintf("k8s-pause failed to determine whether the pod must stay suspended, pod is resumed: %s", err))
+		case WebhookErrorPolicySuspend:
+			webhookErrorsTotal.WithLabelValues(string(WebhookErrorPolicySuspend)).Inc()
+			return admission.Denied("k8s-pause failed to determine the suspend state; pod must stay suspended").WithWarnings(
+				fmt.Sprintf("k8s-pause failed to determine whether the pod must stay suspended, pod stays suspended: %s", err))
+		default:
+			webhookErrorsTotal.WithLabelValues(string(WebhookErrorPolicyDeny)).Inc()
+			return admission.Errored(http.StatusInternalServerError, err)
 		}
 	}
 
-	if p, ok := ns.Annotations[profileAnnotation]; ok {
-		var profile v1beta1.ResumeProfile
-		err := a.Client.Get(ctx, client.ObjectKey{
-			Name:      p,
-			Namespace: pod.Namespace,
-		}, &profile)
+	if reason != "" {
+		return admission.Denied(fmt.Sprintf("%s; pod must stay suspended", reason))
+	}
+
+	return admission.Allowed("")
+}
+
+// suspendReason explains why the pod must not be scheduled, an empty reason means the pod is allowed to be scheduled.
+// The mode defines how the pod is parked in the namespace.
+func (a *Scheduler) suspendReason(ctx context.Context, namespace string, pod corev1.Pod) (string, SuspendMode, error) {
+	state, err := a.namespaceState(ctx, namespace)
+	if err != nil {
+		return "", a.Mode, err
+	}
 
+	mode := state.suspendMode(a.Mode)
+	if state.ignores(pod) {
+		return "", mode, nil
+	}
+
+	if state.Suspend {
+		return fmt.Sprintf("namespace %s is suspended by k8s-pause", namespace), mode, nil
+	}
+
+	if state.Profile != "" {
+		resolved, err := resolveResumeProfiles(ctx, a.resumeProfile, namespace, profileNames(state.Profile))
 		if err != nil {
-			return admission.Errored(http.StatusBadRequest, err)
+			return "", mode, err
 		}
 
-		if !matchesResumeProfile(*pod, profile) {
-			suspend = true
+		if !matchesResumeProfile(pod, resolved) {
+			return fmt.Sprintf("pod not matched by %s", resolved), mode, nil
 		}
 	}
 
-	if !suspend {
-		return admission.Response{
-			AdmissionResponse: admissionv1.AdmissionResponse{
-				Allowed: true,
-			},
+	return "", mode, nil
+}
+
+// park prevents the pod from being scheduled and records the reason on the pod.
+// The patch only touches fields owned by k8s-pause, it is only valid for pods which are created.
+func (a *Scheduler) park(pod *corev1.Pod, reason string, mode SuspendMode) admission.Response {
+	annotations := map[string]string{
+		reasonAnnotation:      reason,
+		previousSchedulerName: originalSchedulerName(*pod),
+	}
+
+	var patches []jsonpatch.JsonPatchOperation
+
+	switch {
+	case mode == SuspendModeSchedulingGate:
+		if hasSchedulingGate(pod, schedulingGateName) {
+			break
+		}
+
+		gate := corev1.PodSchedulingGate{Name: schedulingGateName}
+		if len(pod.Spec.SchedulingGates) == 0 {
+			patches = append(patches, jsonpatch.NewOperation("add", "/spec/schedulingGates", []corev1.PodSchedulingGate{gate}))
+		} else {
+			patches = append(patches, jsonpatch.NewOperation("add", "/spec/schedulingGates/-", gate))
 		}
+	case pod.Spec.SchedulerName != schedulerName:
+		patches = append(patches, jsonpatch.NewOperation("add", "/spec/schedulerName", schedulerName))
 	}
 
-	pod.Spec.SchedulerName = schedulerName
+	patches = append(patches, annotationPatches(pod, annotations)...)
+	return admission.Patched("", patches...)
+}
 
-	marshaledPod, err := json.Marshal(pod)
-	if err != nil {
+// annotationPatches creates the json patch operations to set the given annotations
+func annotationPatches(obj metav1.Object, annotations map[string]string) []jsonpatch.JsonPatchOperation {
+	if obj.GetAnnotations() == nil {
+		return []jsonpatch.JsonPatchOperation{
+			jsonpatch.NewOperation("add", "/metadata/annotations", annotations),
+		}
+	}
+
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+
+	// Keep the patch deterministic
+	sort.Strings(keys)
+
+	var patches []jsonpatch.JsonPatchOperation
+	for _, key := range keys {
+		if value, ok := obj.GetAnnotations()[key]; ok && value == annotations[key] {
+			continue
+		}
+
+		patches = append(patches, jsonpatch.NewOperation("add", "/metadata/annotations/"+escapeJSONPointer(key), annotations[key]))
+	}
+
+	return patches
+}
+
+// escapeJSONPointer escapes a json pointer reference token as defined in RFC 6901
+func escapeJSONPointer(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+// handleError applies the configured WebhookErrorPolicy if the suspend state of a pod could not be determined
+func (a *Scheduler) handleError(pod *corev1.Pod, err error) admission.Response {
+	switch a.OnError {
+	case WebhookErrorPolicyAllow:
+		webhookErrorsTotal.WithLabelValues(string(WebhookErrorPolicyAllow)).Inc()
+		return admission.Allowed("").WithWarnings(
+			fmt.Sprintf("k8s-pause failed to determine whether the pod must be suspended, pod is allowed to be scheduled: %s", err))
+	case WebhookErrorPolicySuspend:
+		webhookErrorsTotal.WithLabelValues(string(WebhookErrorPolicySuspend)).Inc()
+		return a.park(pod, "k8s-pause failed to determine the suspend state", a.Mode).WithWarnings(
+			fmt.Sprintf("k8s-pause failed to determine whether the pod must be suspended, pod will not be scheduled: %s", err))
+	default:
+		webhookErrorsTotal.WithLabelValues(string(WebhookErrorPolicyDeny)).Inc()
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
+}
+
+// namespaceState looks up the suspend state of a namespace from the state cache, the API is only queried if the cache is not synced yet
+func (a *Scheduler) namespaceState(ctx context.Context, name string) (namespaceSuspendState, error) {
+	if a.State != nil {
+		if state, ok := a.State.namespace(name); ok {
+			return state, nil
+		}
+	}
+
+	var ns corev1.Namespace
+	err := a.Client.Get(ctx, types.NamespacedName{
+		Name: name,
+	}, &ns)
+
+	if err != nil {
+		return namespaceSuspendState{}, err
+	}
+
+	state, _, err := suspendStateFor(ctx, a.Client, ns, a.Protected)
+	return state, err
+}
+
+// resumeProfile looks up a resume profile from the state cache, the API is only queried if the cache is not synced yet
+func (a *Scheduler) resumeProfile(ctx context.Context, key client.ObjectKey) (*v1beta1.ResumeProfile, error) {
+	if a.State != nil {
+		if profile, ok := a.State.profile(key); ok {
+			if profile == nil {
+				return nil, apierrors.NewNotFound(v1beta1.GroupVersion.WithResource("resumeprofiles").GroupResource(), key.Name)
+			}
+
+			return profile, nil
+		}
+	}
+
+	var profile v1beta1.ResumeProfile
+	if err := a.Client.Get(ctx, key, &profile); err != nil {
+		return nil, err
+	}
+
+	return &profile, nil
+}
+
+// parkPod prevents the pod from being scheduled using the given mode.
+// It must only be applied to pods which are about to be created since scheduling gates can not be added to existing pods.
+func parkPod(pod *corev1.Pod, mode SuspendMode) {
+	if mode == SuspendModeSchedulingGate {
+		if !hasSchedulingGate(pod, schedulingGateName) {
+			pod.Spec.SchedulingGates = append(pod.Spec.SchedulingGates, corev1.PodSchedulingGate{
+				Name: schedulingGateName,
+			})
+		}
+
+		return
+	}
+
+	pod.Spec.SchedulerName = schedulerName
+}
+
+// isPodParked returns true if the pod is prevented from being scheduled by any of the supported modes
+func isPodParked(pod corev1.Pod) bool {
+	return pod.Spec.SchedulerName == schedulerName || hasSchedulingGate(&pod, schedulingGateName)
+}
 
-	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
+// isPodIgnored returns true if the pod is excluded from k8s-pause by the ignore annotation
+func isPodIgnored(pod corev1.Pod) bool {
+	return pod.Annotations[ignoreAnnotation] == "true"
 }
 
 // InjectDecoder injects the decoder.
@@ -0,0 +1,647 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const benchmarkNamespaces = 500
+
+// benchmarkScheduler creates a scheduler for a cluster with many namespaces where every namespace has an active resume profile
+func benchmarkScheduler(b *testing.B, cached bool) (*Scheduler, admission.Request) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		b.Fatal(err)
+	}
+
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		b.Fatal(err)
+	}
+
+	var objects []client.Object
+	state := NewSuspendStateCache(ProtectedNamespaces{})
+	state.synced = []toolscache.InformerSynced{func() bool { return true }}
+
+	for i := 0; i < benchmarkNamespaces; i++ {
+		ns := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: fmt.Sprintf("ns-%d", i),
+				Annotations: map[string]string{
+					profileAnnotation: "backend",
+				},
+			},
+		}
+
+		profile := &v1beta1.ResumeProfile{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "backend",
+				Namespace: ns.Name,
+			},
+			Spec: v1beta1.ResumeProfileSpec{
+				ProfileSelectors: v1beta1.ProfileSelectors{
+					PodSelector: []metav1.LabelSelector{
+						{MatchLabels: map[string]string{"app": "backend"}},
+					},
+				},
+			},
+		}
+
+		objects = append(objects, ns, profile)
+		state.setNamespace(ns)
+		state.setProfile(profile)
+	}
+
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	scheduler := &Scheduler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		Mode:   SuspendModeScheduler,
+	}
+
+	if cached {
+		scheduler.State = state
+	}
+
+	if err := scheduler.InjectDecoder(decoder); err != nil {
+		b.Fatal(err)
+	}
+
+	pod := corev1.Pod{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       "Pod",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "frontend",
+			Namespace: "ns-250",
+			Labels:    map[string]string{"app": "frontend"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "frontend", Image: "frontend:latest"},
+			},
+		},
+	}
+
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	req := admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Namespace: pod.Namespace,
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+
+	return scheduler, req
+}
+
+func BenchmarkSchedulerHandle(b *testing.B) {
+	for _, bench := range []struct {
+		name   string
+		cached bool
+	}{
+		{name: "client", cached: false},
+		{name: "state-cache", cached: true},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			scheduler, req := benchmarkScheduler(b, bench.cached)
+			ctx := context.TODO()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				res := scheduler.Handle(ctx, req)
+				if !res.Allowed || len(res.Patches) == 0 {
+					b.Fatalf("expected pod to be suspended, got %#v", res.AdmissionResponse)
+				}
+			}
+		})
+	}
+}
+
+func TestSchedulerParkPatches(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		mode     SuspendMode
+		pod      corev1.Pod
+		expected []string
+	}{
+		{
+			name: "scheduler without annotations",
+			mode: SuspendModeScheduler,
+			pod: corev1.Pod{
+				Spec: corev1.PodSpec{SchedulerName: "custom"},
+			},
+			expected: []string{
+				`{"op":"add","path":"/spec/schedulerName","value":"k8s-pause"}`,
+				`{"op":"add","path":"/metadata/annotations","value":{"k8s-pause/previousScheduler":"custom","k8s-pause/reason":"test"}}`,
+			},
+		},
+		{
+			name: "scheduler with annotations",
+			mode: SuspendModeScheduler,
+			pod: corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"foo": "bar"}},
+				Spec:       corev1.PodSpec{SchedulerName: "default-scheduler"},
+			},
+			expected: []string{
+				`{"op":"add","path":"/spec/schedulerName","value":"k8s-pause"}`,
+				`{"op":"add","path":"/metadata/annotations/k8s-pause~1previousScheduler","value":"default-scheduler"}`,
+				`{"op":"add","path":"/metadata/annotations/k8s-pause~1reason","value":"test"}`,
+			},
+		},
+		{
+			name: "already parked by scheduler",
+			mode: SuspendModeScheduler,
+			pod: corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{previousSchedulerName: "custom", reasonAnnotation: "test"}},
+				Spec:       corev1.PodSpec{SchedulerName: schedulerName},
+			},
+		},
+		{
+			name: "parked by scheduler without previous scheduler",
+			mode: SuspendModeScheduler,
+			pod: corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{reasonAnnotation: "test"}},
+				Spec:       corev1.PodSpec{SchedulerName: schedulerName},
+			},
+			expected: []string{
+				`{"op":"add","path":"/metadata/annotations/k8s-pause~1previousScheduler","value":"default-scheduler"}`,
+			},
+		},
+		{
+			name: "gate without gates",
+			mode: SuspendModeSchedulingGate,
+			pod:  corev1.Pod{},
+			expected: []string{
+				`{"op":"add","path":"/spec/schedulingGates","value":[{"name":"k8s-pause.infra.doodle.com/suspended"}]}`,
+				`{"op":"add","path":"/metadata/annotations","value":{"k8s-pause/previousScheduler":"default-scheduler","k8s-pause/reason":"test"}}`,
+			},
+		},
+		{
+			name: "already parked by gate",
+			mode: SuspendModeSchedulingGate,
+			pod: corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{previousSchedulerName: "default-scheduler", reasonAnnotation: "test"}},
+				Spec:       corev1.PodSpec{SchedulingGates: []corev1.PodSchedulingGate{{Name: schedulingGateName}}},
+			},
+		},
+		{
+			name: "gate with existing gates",
+			mode: SuspendModeSchedulingGate,
+			pod: corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}},
+				Spec:       corev1.PodSpec{SchedulingGates: []corev1.PodSchedulingGate{{Name: "other"}}},
+			},
+			expected: []string{
+				`{"op":"add","path":"/spec/schedulingGates/-","value":{"name":"k8s-pause.infra.doodle.com/suspended"}}`,
+				`{"op":"add","path":"/metadata/annotations/k8s-pause~1previousScheduler","value":"default-scheduler"}`,
+				`{"op":"add","path":"/metadata/annotations/k8s-pause~1reason","value":"test"}`,
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			scheduler := &Scheduler{Mode: test.mode}
+			res := scheduler.park(&test.pod, "test", scheduler.Mode)
+
+			if !res.Allowed {
+				t.Fatalf("expected pod to be allowed")
+			}
+
+			var patches []string
+			for _, patch := range res.Patches {
+				b, err := json.Marshal(patch)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				patches = append(patches, string(b))
+			}
+
+			if fmt.Sprint(patches) != fmt.Sprint(test.expected) {
+				t.Errorf("expected patches %v, got %v", test.expected, patches)
+			}
+		})
+	}
+}
+
+func TestSchedulerHandleUpdate(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suspended := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "suspended",
+			Annotations: map[string]string{suspendedAnnotation: "true"},
+		},
+	}
+
+	resumed := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "resumed",
+		},
+	}
+
+	scheduler := &Scheduler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(suspended, resumed).Build(),
+		Mode:   SuspendModeSchedulingGate,
+	}
+
+	if err := scheduler.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+
+	parked := corev1.Pod{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "pod",
+			Annotations: map[string]string{previousSchedulerName: "default-scheduler"},
+		},
+		Spec: corev1.PodSpec{
+			SchedulingGates: []corev1.PodSchedulingGate{{Name: schedulingGateName}},
+		},
+	}
+
+	unparked := *parked.DeepCopy()
+	unparked.Spec.SchedulingGates = nil
+	unparked.Annotations = nil
+
+	tampered := *parked.DeepCopy()
+	tampered.Annotations[previousSchedulerName] = "custom"
+
+	labeled := *parked.DeepCopy()
+	labeled.Labels = map[string]string{"foo": "bar"}
+
+	for _, test := range []struct {
+		name      string
+		namespace string
+		old       corev1.Pod
+		new       corev1.Pod
+		allowed   bool
+	}{
+		{name: "label change is allowed", namespace: "suspended", old: parked, new: labeled, allowed: true},
+		{name: "running pod in suspended namespace is not parked on update", namespace: "suspended", old: unparked, new: unparked, allowed: true},
+		{name: "previous scheduler can not be changed", namespace: "resumed", old: parked, new: tampered, allowed: false},
+		{name: "pod in suspended namespace can not be resumed", namespace: "suspended", old: parked, new: unparked, allowed: false},
+		{name: "pod in resumed namespace can be resumed", namespace: "resumed", old: parked, new: unparked, allowed: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			oldRaw, err := json.Marshal(test.old)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			newRaw, err := json.Marshal(test.new)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res := scheduler.Handle(context.TODO(), admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Operation: admissionv1.Update,
+					Namespace: test.namespace,
+					Object:    runtime.RawExtension{Raw: newRaw},
+					OldObject: runtime.RawExtension{Raw: oldRaw},
+				},
+			})
+
+			if res.Allowed != test.allowed {
+				t.Errorf("expected allowed to be %v, got %#v", test.allowed, res.AdmissionResponse)
+			}
+
+			if len(res.Patches) != 0 {
+				t.Errorf("expected updates not to be patched, got %v", res.Patches)
+			}
+		})
+	}
+}
+
+func TestSchedulerHandleCreate(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suspended := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "suspended",
+			Annotations: map[string]string{suspendedAnnotation: "true"},
+		},
+	}
+
+	resumed := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "resumed",
+		},
+	}
+
+	scheduler := &Scheduler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(suspended, resumed).Build(),
+		Mode:   SuspendModeSchedulingGate,
+	}
+
+	if err := scheduler.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := json.Marshal(corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "pod"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name      string
+		namespace string
+		gated     bool
+	}{
+		{name: "pod in suspended namespace is gated", namespace: "suspended", gated: true},
+		{name: "pod in resumed namespace is not gated", namespace: "resumed", gated: false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			res := scheduler.Handle(context.TODO(), admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Operation: admissionv1.Create,
+					Namespace: test.namespace,
+					Object:    runtime.RawExtension{Raw: raw},
+				},
+			})
+
+			if !res.Allowed {
+				t.Fatalf("expected pod to be allowed, got %#v", res.AdmissionResponse)
+			}
+
+			var gated bool
+			for _, patch := range res.Patches {
+				if patch.Path == "/spec/schedulingGates" {
+					gated = true
+				}
+			}
+
+			if gated != test.gated {
+				t.Errorf("expected gated to be %v, got patches %v", test.gated, res.Patches)
+			}
+		})
+	}
+}
+
+func TestSchedulerHandleWarnings(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suspended := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "suspended", Annotations: map[string]string{suspendedAnnotation: "true"}}}
+	profiled := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "profiled", Annotations: map[string]string{profileAnnotation: "backend"}}}
+	resumed := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "resumed"}}
+	profile := &v1beta1.ResumeProfile{
+		ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "profiled"},
+		Spec: v1beta1.ResumeProfileSpec{
+			ProfileSelectors: v1beta1.ProfileSelectors{
+				PodSelector: []metav1.LabelSelector{{MatchLabels: map[string]string{"app": "backend"}}},
+			},
+		},
+	}
+
+	scheduler := &Scheduler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(suspended, profiled, resumed, profile).Build(),
+		Mode:   SuspendModeScheduler,
+	}
+
+	if err := scheduler.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name      string
+		namespace string
+		app       string
+		reason    string
+	}{
+		{name: "suspended namespace", namespace: "suspended", app: "backend", reason: "namespace suspended is suspended by k8s-pause"},
+		{name: "pod not matched by profile", namespace: "profiled", app: "frontend", reason: "pod not matched by ResumeProfile backend"},
+		{name: "pod matched by profile", namespace: "profiled", app: "backend"},
+		{name: "resumed namespace", namespace: "resumed", app: "frontend"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			raw, err := json.Marshal(corev1.Pod{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+				ObjectMeta: metav1.ObjectMeta{Name: "pod", Labels: map[string]string{"app": test.app}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res := scheduler.Handle(context.TODO(), admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Operation: admissionv1.Create,
+					Namespace: test.namespace,
+					Object:    runtime.RawExtension{Raw: raw},
+				},
+			})
+
+			if !res.Allowed {
+				t.Fatalf("expected pod to be allowed, got %#v", res.AdmissionResponse)
+			}
+
+			if test.reason == "" {
+				if len(res.Warnings) != 0 || len(res.Patches) != 0 {
+					t.Errorf("expected pod not to be parked, got warnings %v and patches %v", res.Warnings, res.Patches)
+				}
+
+				return
+			}
+
+			expected := fmt.Sprintf("%s; pod will not be scheduled", test.reason)
+			if len(res.Warnings) != 1 || res.Warnings[0] != expected {
+				t.Errorf("expected warning %q, got %v", expected, res.Warnings)
+			}
+
+			var annotated bool
+			for _, patch := range res.Patches {
+				if annotations, ok := patch.Value.(map[string]string); ok && annotations[reasonAnnotation] == test.reason {
+					annotated = true
+				}
+			}
+
+			if !annotated {
+				t.Errorf("expected reason annotation %q, got patches %v", test.reason, res.Patches)
+			}
+		})
+	}
+}
+
+func TestSchedulerHandleError(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pod := corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "pod"},
+	}
+
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parked := *pod.DeepCopy()
+	parked.Spec.SchedulerName = schedulerName
+	parked.Annotations = map[string]string{previousSchedulerName: "default-scheduler"}
+
+	parkedRaw, err := json.Marshal(parked)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the namespace does not exist, its suspend state can not be determined
+	create := admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Namespace: "unknown",
+			Object:    runtime.RawExtension{Raw: raw},
+		},
+	}
+
+	update := admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Update,
+			Namespace: "unknown",
+			Object:    runtime.RawExtension{Raw: raw},
+			OldObject: runtime.RawExtension{Raw: parkedRaw},
+		},
+	}
+
+	for _, test := range []struct {
+		name     string
+		policy   WebhookErrorPolicy
+		req      admission.Request
+		decision WebhookErrorPolicy
+		allowed  bool
+		code     int32
+		parked   bool
+	}{
+		{name: "create is allowed", policy: WebhookErrorPolicyAllow, req: create, decision: WebhookErrorPolicyAllow, allowed: true},
+		{name: "create is suspended", policy: WebhookErrorPolicySuspend, req: create, decision: WebhookErrorPolicySuspend, allowed: true, parked: true},
+		{name: "create is denied", policy: WebhookErrorPolicyDeny, req: create, decision: WebhookErrorPolicyDeny, code: http.StatusInternalServerError},
+		{name: "create is denied by default", req: create, decision: WebhookErrorPolicyDeny, code: http.StatusInternalServerError},
+		{name: "resume is allowed", policy: WebhookErrorPolicyAllow, req: update, decision: WebhookErrorPolicyAllow, allowed: true},
+		{name: "resume is denied if pods are suspended on error", policy: WebhookErrorPolicySuspend, req: update, decision: WebhookErrorPolicySuspend, code: http.StatusForbidden},
+		{name: "resume is denied", policy: WebhookErrorPolicyDeny, req: update, decision: WebhookErrorPolicyDeny, code: http.StatusInternalServerError},
+		{name: "resume is denied by default", req: update, decision: WebhookErrorPolicyDeny, code: http.StatusInternalServerError},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			scheduler := &Scheduler{
+				Client:  fake.NewClientBuilder().WithScheme(scheme).Build(),
+				Mode:    SuspendModeScheduler,
+				OnError: test.policy,
+			}
+
+			if err := scheduler.InjectDecoder(decoder); err != nil {
+				t.Fatal(err)
+			}
+
+			errors := testutil.ToFloat64(webhookErrorsTotal.WithLabelValues(string(test.decision)))
+			res := scheduler.Handle(context.TODO(), test.req)
+
+			if res.Allowed != test.allowed {
+				t.Errorf("expected allowed to be %v, got %#v", test.allowed, res.AdmissionResponse)
+			}
+
+			if !test.allowed && (res.Result == nil || res.Result.Code != test.code) {
+				t.Errorf("expected status code %d, got %#v", test.code, res.Result)
+			}
+
+			if test.policy != WebhookErrorPolicyDeny && test.policy != "" && len(res.Warnings) == 0 {
+				t.Error("expected a warning with the cause")
+			}
+
+			if parked := len(res.Patches) > 0; parked != test.parked {
+				t.Errorf("expected parked to be %v, got patches %v", test.parked, res.Patches)
+			}
+
+			if increase := testutil.ToFloat64(webhookErrorsTotal.WithLabelValues(string(test.decision))) - errors; increase != 1 {
+				t.Errorf("expected the %s decision to be counted once, got %v", test.decision, increase)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// batchRequeueAfter is the delay after which a namespace is reconciled again once a batch is exhausted
+	batchRequeueAfter = time.Second
+)
+
+// podBatch limits the number of pod operations within a single reconciliation
+type podBatch struct {
+	limiter *rate.Limiter
+	size    int
+	count   int
+}
+
+func newPodBatch(limiter *rate.Limiter, size int) *podBatch {
+	return &podBatch{
+		limiter: limiter,
+		size:    size,
+	}
+}
+
+// next blocks until the next pod operation is allowed by the rate limiter.
+// It returns false if the batch is exhausted and remaining pods need to be processed in a later reconciliation.
+func (b *podBatch) next(ctx context.Context) (bool, error) {
+	if b.size > 0 && b.count >= b.size {
+		return false, nil
+	}
+
+	if b.limiter != nil {
+		if err := b.limiter.Wait(ctx); err != nil {
+			return false, err
+		}
+	}
+
+	b.count++
+	return true, nil
+}
+
+// release returns the slot of a failed pod operation, pods failing on every attempt must not starve the remaining pods of the namespace
+func (b *podBatch) release() {
+	if b.count > 0 {
+		b.count--
+	}
+}
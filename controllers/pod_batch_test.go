@@ -0,0 +1,58 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestPodBatch(t *testing.T) {
+	batch := newPodBatch(nil, 2)
+	for i, expected := range []bool{true, true, false} {
+		if ok, err := batch.next(context.TODO()); err != nil || ok != expected {
+			t.Errorf("expected operation %d to be allowed %v, got %v (%v)", i, expected, ok, err)
+		}
+	}
+
+	batch.release()
+	if ok, _ := batch.next(context.TODO()); !ok {
+		t.Error("expected a released slot to be available again")
+	}
+
+	unlimited := newPodBatch(nil, 0)
+	for i := 0; i < 100; i++ {
+		if ok, _ := unlimited.next(context.TODO()); !ok {
+			t.Fatalf("expected unlimited batch to allow operation %d", i)
+		}
+	}
+
+	// the burst of one is consumed by the first operation, the second one waits for the limiter
+	limited := newPodBatch(rate.NewLimiter(rate.Limit(0.001), 1), 0)
+	if ok, err := limited.next(context.TODO()); !ok || err != nil {
+		t.Errorf("expected first operation to be allowed, got %v (%v)", ok, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+
+	if ok, err := limited.next(ctx); ok || err == nil {
+		t.Errorf("expected rate limited operation to fail once the context is done, got %v", ok)
+	}
+}
@@ -0,0 +1,267 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// conditionProfileTransition is the namespace condition reporting the transition of pods into the state of the active profile
+	conditionProfileTransition = corev1.NamespaceConditionType("ProfileTransition")
+
+	reasonTransitioning = "Transitioning"
+	reasonTransitioned  = "Transitioned"
+
+	// transitionPlanNames is the maximum number of pod names listed per group in the transition plan
+	transitionPlanNames = 5
+)
+
+// transitionPlan is the difference between the current state of the pods in a resumed namespace and the state defined by the active profile
+type transitionPlan struct {
+	// resume are parked pods which are allowed to run
+	resume []corev1.Pod
+	// park are running pods which are not allowed to run
+	park []corev1.Pod
+	// ignored are pods which would change their state but are annotated with the ignore annotation
+	ignored []string
+
+	running int
+	parked  int
+}
+
+// planTransition computes which pods move from parked to running and vice versa, pods which are terminating or finished are left alone.
+// Pods are resumed in the order defined by the policy and parked in reverse order.
+func planTransition(pods []corev1.Pod, profile *resolvedProfile, state namespaceSuspendState) transitionPlan {
+	var plan transitionPlan
+	for _, pod := range pods {
+		if isPodTerminated(pod) {
+			continue
+		}
+
+		allowed := profile == nil || matchesResumeProfile(pod, *profile)
+		parked := isPodParked(pod)
+
+		switch {
+		case allowed == !parked:
+		case state.ignores(pod):
+			plan.ignored = append(plan.ignored, pod.Name)
+			allowed = !parked
+		case allowed:
+			plan.resume = append(plan.resume, pod)
+		default:
+			plan.park = append(plan.park, pod)
+		}
+
+		if allowed {
+			plan.running++
+		} else {
+			plan.parked++
+		}
+	}
+
+	state.sortByOrder(plan.resume, false)
+	state.sortByOrder(plan.park, true)
+	return plan
+}
+
+// isPodTerminated returns true if the pod is terminating or finished
+func isPodTerminated(pod corev1.Pod) bool {
+	return pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+// previousGroupsReady returns true if all pods of the ordering groups before the given group which are allowed to run are ready
+func previousGroupsReady(pods []corev1.Pod, profile *resolvedProfile, state namespaceSuspendState, group int) bool {
+	for _, pod := range pods {
+		if state.orderGroup(pod) >= group || isPodTerminated(pod) || state.ignores(pod) {
+			continue
+		}
+
+		if profile != nil && !matchesResumeProfile(pod, *profile) {
+			continue
+		}
+
+		if isPodParked(pod) || !isPodReady(pod) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isPodReady(pod corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+func (p transitionPlan) pending() bool {
+	return len(p.resume) > 0 || len(p.park) > 0
+}
+
+// message describes the plan, pending moves are listed with the names of the affected pods
+func (p transitionPlan) message(profile *resolvedProfile) string {
+	target := "no ResumeProfile"
+	if profile != nil {
+		target = profile.String()
+	}
+
+	var parts []string
+	if p.pending() {
+		if len(p.resume) > 0 {
+			parts = append(parts, fmt.Sprintf("resuming %s", podNames(p.resume)))
+		}
+
+		if len(p.park) > 0 {
+			parts = append(parts, fmt.Sprintf("parking %s", podNames(p.park)))
+		}
+
+		parts[0] = fmt.Sprintf("transition to %s: %s", target, parts[0])
+	} else {
+		parts = append(parts, fmt.Sprintf("%s active: %d %s running, %d %s parked", target, p.running, plural(p.running), p.parked, plural(p.parked)))
+	}
+
+	if len(p.ignored) > 0 {
+		parts = append(parts, fmt.Sprintf("%d ignored %s", len(p.ignored), listNames(p.ignored)))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func podNames(pods []corev1.Pod) string {
+	names := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+
+	return fmt.Sprintf("%d %s %s", len(pods), plural(len(pods)), listNames(names))
+}
+
+func listNames(names []string) string {
+	if len(names) > transitionPlanNames {
+		return fmt.Sprintf("(%s and %d more)", strings.Join(names[:transitionPlanNames], ", "), len(names)-transitionPlanNames)
+	}
+
+	return fmt.Sprintf("(%s)", strings.Join(names, ", "))
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return "pod"
+	}
+
+	return "pods"
+}
+
+// transition moves the pods of a resumed namespace into the state defined by the active profile.
+// Only pods whose state differs are touched: parked pods allowed by the profile are resumed and running pods not allowed are parked.
+// Without an active profile all parked pods are resumed.
+// The transition is only reported as done once every move succeeded and the current pods match the profile.
+func (r *NamespaceReconciler) transition(ctx context.Context, ns *corev1.Namespace, profile *resolvedProfile, state namespaceSuspendState, batch *podBatch, logger logr.Logger) (ctrl.Result, error) {
+	var list corev1.PodList
+	if err := r.Client.List(ctx, &list, client.InNamespace(ns.Name)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	plan := planTransition(list.Items, profile, state)
+	report := profile != nil || getNamespaceCondition(*ns, conditionProfileTransition) != nil
+
+	if plan.pending() {
+		logger.Info("transition pods", "resume", len(plan.resume), "park", len(plan.park), "ignored", len(plan.ignored))
+		if report {
+			if err := r.patchCondition(ctx, ns, conditionProfileTransition, corev1.ConditionFalse, reasonTransitioning, plan.message(profile)); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	// Pods which could not be moved are reported once the batch is done, the transition is not completed while any move failed
+	var errs []error
+
+	for _, pod := range plan.resume {
+		if group := state.orderGroup(pod); group > 0 && !previousGroupsReady(list.Items, profile, state, group) {
+			logger.Info("waiting for pods of previous ordering groups to become ready", "pod", pod.Name)
+			return ctrl.Result{RequeueAfter: batchRequeueAfter}, transitionError(errs)
+		}
+
+		if ok, err := batch.next(ctx); err != nil {
+			return ctrl.Result{}, err
+		} else if !ok {
+			logger.Info("resume batch exhausted, continue later")
+			return ctrl.Result{RequeueAfter: batchRequeueAfter}, transitionError(errs)
+		}
+
+		if err := r.resumePod(ctx, pod, logger); err != nil {
+			logger.Error(err, "failed to resume pod", "pod", pod.Name)
+			batch.release()
+			errs = append(errs, fmt.Errorf("resume pod %s: %w", pod.Name, err))
+		}
+	}
+
+	for _, pod := range plan.park {
+		if ok, err := batch.next(ctx); err != nil {
+			return ctrl.Result{}, err
+		} else if !ok {
+			logger.Info("suspend batch exhausted, continue later")
+			return ctrl.Result{RequeueAfter: batchRequeueAfter}, transitionError(errs)
+		}
+
+		if err := r.suspendPod(ctx, pod, state, logger); err != nil {
+			logger.Error(err, "failed to suspend pod", "pod", pod.Name)
+			batch.release()
+			errs = append(errs, fmt.Errorf("suspend pod %s: %w", pod.Name, err))
+		}
+	}
+
+	if err := transitionError(errs); err != nil || !report {
+		return ctrl.Result{}, err
+	}
+
+	// The result is reported from the current pods, moved pods may have been recreated or may not have changed their state yet
+	var current corev1.PodList
+	if err := r.Client.List(ctx, &current, client.InNamespace(ns.Name)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	plan = planTransition(current.Items, profile, state)
+	if plan.pending() {
+		return ctrl.Result{RequeueAfter: batchRequeueAfter}, r.patchCondition(ctx, ns, conditionProfileTransition, corev1.ConditionFalse, reasonTransitioning, plan.message(profile))
+	}
+
+	return ctrl.Result{}, r.patchCondition(ctx, ns, conditionProfileTransition, corev1.ConditionTrue, reasonTransitioned, plan.message(profile))
+}
+
+// transitionError aggregates the errors of pods which could not be moved during a transition
+func transitionError(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("failed to transition %d %s: %w", len(errs), plural(len(errs)), utilerrors.NewAggregate(errs))
+}
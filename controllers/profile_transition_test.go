@@ -0,0 +1,166 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestPlanTransition(t *testing.T) {
+	pod := func(name, app string, parked, ignored bool) corev1.Pod {
+		pod := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"app": app}},
+		}
+
+		if parked {
+			pod.Spec.SchedulerName = schedulerName
+		}
+
+		if ignored {
+			pod.Annotations = map[string]string{ignoreAnnotation: "true"}
+		}
+
+		return pod
+	}
+
+	finished := pod("job", "worker", false, false)
+	finished.Status.Phase = corev1.PodSucceeded
+
+	pods := []corev1.Pod{
+		pod("api-running", "api", false, false),
+		pod("api-parked", "api", true, false),
+		pod("worker-running", "worker", false, false),
+		pod("worker-parked", "worker", true, false),
+		pod("worker-ignored", "worker", false, true),
+		finished,
+	}
+
+	profile := &resolvedProfile{
+		Name: "api",
+		Selectors: []v1beta1.ResolvedSelectors{
+			{
+				Profile: "api",
+				ProfileSelectors: v1beta1.ProfileSelectors{
+					PodSelector: []metav1.LabelSelector{{MatchLabels: map[string]string{"app": "api"}}},
+				},
+			},
+		},
+	}
+
+	plan := planTransition(pods, profile, namespaceSuspendState{})
+	if len(plan.resume) != 1 || plan.resume[0].Name != "api-parked" {
+		t.Errorf("expected only api-parked to be resumed, got %v", podNames(plan.resume))
+	}
+
+	if len(plan.park) != 1 || plan.park[0].Name != "worker-running" {
+		t.Errorf("expected only worker-running to be parked, got %v", podNames(plan.park))
+	}
+
+	expected := "transition to ResumeProfile api: resuming 1 pod (api-parked), parking 1 pod (worker-running), 1 ignored (worker-ignored)"
+	if message := plan.message(profile); message != expected {
+		t.Errorf("expected message %q, got %q", expected, message)
+	}
+
+	plan.resume, plan.park = nil, nil
+	expected = "ResumeProfile api active: 3 pods running, 2 pods parked, 1 ignored (worker-ignored)"
+	if message := plan.message(profile); message != expected {
+		t.Errorf("expected message %q, got %q", expected, message)
+	}
+
+	plan = planTransition(pods, nil, namespaceSuspendState{})
+	if len(plan.resume) != 2 || len(plan.park) != 0 || len(plan.ignored) != 0 {
+		t.Errorf("expected all parked pods to be resumed without profile, got %s", plan.message(nil))
+	}
+}
+
+func TestTransitionCondition(t *testing.T) {
+	owner := []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "worker-7d9f", UID: "uid"}}
+	parked := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "staging", Labels: map[string]string{"app": "api"}},
+		Spec:       corev1.PodSpec{SchedulerName: schedulerName},
+		Status:     corev1.PodStatus{Phase: phaseSuspended},
+	}
+
+	running := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "staging", Labels: map[string]string{"app": "worker"}, OwnerReferences: owner},
+	}
+
+	profile := &resolvedProfile{
+		Name: "api",
+		Selectors: []v1beta1.ResolvedSelectors{
+			{
+				Profile: "api",
+				ProfileSelectors: v1beta1.ProfileSelectors{
+					PodSelector: []metav1.LabelSelector{{MatchLabels: map[string]string{"app": "api"}}},
+				},
+			},
+		},
+	}
+
+	for _, test := range []struct {
+		name    string
+		failing map[string]bool
+		err     bool
+		status  corev1.ConditionStatus
+		reason  string
+		message string
+	}{
+		{
+			name:    "transitioned",
+			status:  corev1.ConditionTrue,
+			reason:  reasonTransitioned,
+			message: "ResumeProfile api active: 1 pod running, 0 pods parked",
+		},
+		{
+			name:    "failed move keeps transitioning",
+			failing: map[string]bool{"worker": true},
+			err:     true,
+			status:  corev1.ConditionFalse,
+			reason:  reasonTransitioning,
+			message: "transition to ResumeProfile api: resuming 1 pod (api), parking 1 pod (worker)",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "staging"}}
+			r := newTestNamespaceReconciler(t, NamespaceReconcilerOptions{}, ns, parked.DeepCopy(), running.DeepCopy())
+			c := r.Client
+			r.Client = failingDeleteClient{WithWatch: c, names: test.failing}
+
+			_, err := r.transition(context.TODO(), ns, profile, namespaceSuspendState{Profile: "api"}, newPodBatch(nil, 0), logr.Discard())
+			if test.err != (err != nil) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+
+			var updated corev1.Namespace
+			if err := c.Get(context.TODO(), client.ObjectKey{Name: "staging"}, &updated); err != nil {
+				t.Fatal(err)
+			}
+
+			condition := getNamespaceCondition(updated, conditionProfileTransition)
+			if condition == nil || condition.Status != test.status || condition.Reason != test.reason || condition.Message != test.message {
+				t.Errorf("expected condition %s/%s %q, got %v", test.status, test.reason, test.message, condition)
+			}
+		})
+	}
+}
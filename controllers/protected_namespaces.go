@@ -0,0 +1,67 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ProtectedNamespaces defines namespaces which are never suspended, even if they are annotated
+type ProtectedNamespaces struct {
+	// Self is the namespace k8s-pause is running in
+	Self string
+
+	// Dependencies is a list of namespaces hosting services the webhook depends on, for instance cert-manager
+	Dependencies []string
+
+	// Names is a list of protected namespace names
+	Names []string
+
+	// Selector protects all namespaces matching the label selector, nil matches no namespaces
+	Selector labels.Selector
+}
+
+// protects returns true if the namespace must not be suspended
+func (p ProtectedNamespaces) protects(ns corev1.Namespace) bool {
+	return p.reason(ns) != ""
+}
+
+// reason explains why a namespace is protected, it is empty if the namespace is not protected
+func (p ProtectedNamespaces) reason(ns corev1.Namespace) string {
+	if p.Self != "" && p.Self == ns.Name {
+		return "namespace hosts k8s-pause itself, suspending it would prevent any pod from being created"
+	}
+
+	for _, name := range p.Dependencies {
+		if name == ns.Name {
+			return "namespace hosts a dependency of the k8s-pause webhook, suspending it would prevent any pod from being created"
+		}
+	}
+
+	for _, name := range p.Names {
+		if name == ns.Name {
+			return "namespace is protected"
+		}
+	}
+
+	if p.Selector != nil && !p.Selector.Empty() && p.Selector.Matches(labels.Set(ns.Labels)) {
+		return "namespace is protected by selector"
+	}
+
+	return ""
+}
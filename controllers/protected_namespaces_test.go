@@ -0,0 +1,109 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestProtectedNamespaces(t *testing.T) {
+	selector, err := labels.Parse("tier=platform")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	namespace := func(name string, labels map[string]string) corev1.Namespace {
+		return corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+
+	for _, test := range []struct {
+		name      string
+		protected ProtectedNamespaces
+		ns        corev1.Namespace
+		reason    string
+	}{
+		{name: "no protection", protected: ProtectedNamespaces{}, ns: namespace("staging", nil)},
+		{name: "protected by name", protected: ProtectedNamespaces{Names: []string{"kube-system", "staging"}}, ns: namespace("staging", nil), reason: "namespace is protected"},
+		{name: "not protected by name", protected: ProtectedNamespaces{Names: []string{"kube-system"}}, ns: namespace("staging", nil)},
+		{name: "protected by selector", protected: ProtectedNamespaces{Selector: selector}, ns: namespace("monitoring", map[string]string{"tier": "platform"}), reason: "namespace is protected by selector"},
+		{name: "not protected by selector", protected: ProtectedNamespaces{Selector: selector}, ns: namespace("staging", map[string]string{"tier": "apps"})},
+		{name: "own namespace", protected: ProtectedNamespaces{Self: "k8s-pause"}, ns: namespace("k8s-pause", nil), reason: "namespace hosts k8s-pause itself, suspending it would prevent any pod from being created"},
+		{name: "dependency namespace", protected: ProtectedNamespaces{Dependencies: []string{"cert-manager"}}, ns: namespace("cert-manager", nil), reason: "namespace hosts a dependency of the k8s-pause webhook, suspending it would prevent any pod from being created"},
+		{name: "own namespace takes precedence", protected: ProtectedNamespaces{Self: "k8s-pause", Names: []string{"k8s-pause"}}, ns: namespace("k8s-pause", nil), reason: "namespace hosts k8s-pause itself, suspending it would prevent any pod from being created"},
+		{name: "empty selector protects nothing", protected: ProtectedNamespaces{Selector: labels.Everything()}, ns: namespace("staging", nil)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if reason := test.protected.reason(test.ns); reason != test.reason {
+				t.Errorf("expected reason %q, got %q", test.reason, reason)
+			}
+
+			if protects := test.protected.protects(test.ns); protects != (test.reason != "") {
+				t.Errorf("expected protects to be %v, got %v", test.reason != "", protects)
+			}
+		})
+	}
+}
+
+func TestRefuseProtected(t *testing.T) {
+	protected := ProtectedNamespaces{Self: "k8s-pause"}
+	for _, test := range []struct {
+		name        string
+		annotations map[string]string
+		refused     bool
+	}{
+		{name: "suspend is refused", annotations: map[string]string{suspendedAnnotation: "true"}, refused: true},
+		{name: "profile is refused", annotations: map[string]string{profileAnnotation: "api"}, refused: true},
+		{name: "resumed namespace is not reported", annotations: nil, refused: false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "k8s-pause", Annotations: test.annotations}}
+			r := newTestNamespaceReconciler(t, NamespaceReconcilerOptions{Protected: protected}, ns)
+			recorder := r.Recorder.(*record.FakeRecorder)
+
+			// the second call must neither patch the status nor record another event
+			for i := 0; i < 2; i++ {
+				if err := r.refuseProtected(context.TODO(), ns, nil, logr.Discard()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			condition := getNamespaceCondition(*ns, conditionSuspended)
+			if !test.refused {
+				if condition != nil || len(recorder.Events) != 0 {
+					t.Errorf("expected no condition and no event, got %v", condition)
+				}
+
+				return
+			}
+
+			if condition == nil || condition.Status != corev1.ConditionFalse || condition.Reason != reasonProtected || condition.Message != protected.reason(*ns) {
+				t.Errorf("expected protected condition, got %v", condition)
+			}
+
+			if len(recorder.Events) != 1 {
+				t.Errorf("expected a single refused event, got %d", len(recorder.Events))
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// conditionResourcesSaved is the namespace condition reporting the resources saved by suspending the namespace
+	conditionResourcesSaved = corev1.NamespaceConditionType("ResourcesSaved")
+
+	// savingsMessage reports the parked requests of a suspended namespace, it is parsed again once the namespace is resumed
+	savingsMessage = "parked pods request %s CPU and %s memory"
+
+	gibibyte = 1 << 30
+)
+
+// savingsObserver remembers when the savings of a namespace were last accounted to the saved resource-hours counters
+type savingsObserver struct {
+	mu           sync.Mutex
+	started      time.Time
+	lastObserved map[string]time.Time
+}
+
+// observe returns the time elapsed since the last observation of the namespace, but at most since the suspension started.
+// Time before the first observation of any namespace is not accounted, it has already been accounted by a previous controller instance.
+func (s *savingsObserver) observe(namespace string, since, now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.lastObserved == nil {
+		s.started = now
+		s.lastObserved = make(map[string]time.Time)
+	}
+
+	if since.Before(s.started) {
+		since = s.started
+	}
+
+	if last, ok := s.lastObserved[namespace]; ok && last.After(since) {
+		since = last
+	}
+
+	s.lastObserved[namespace] = now
+	return now.Sub(since)
+}
+
+func (s *savingsObserver) forget(namespace string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.lastObserved, namespace)
+}
+
+// podRequests returns the effective resource requests of a pod, which is the larger of the sum of all containers and any init container, plus the pod overhead
+func podRequests(pod corev1.Pod) corev1.ResourceList {
+	requests := corev1.ResourceList{}
+	for _, container := range pod.Spec.Containers {
+		addResources(requests, container.Resources.Requests)
+	}
+
+	for _, container := range pod.Spec.InitContainers {
+		for name, quantity := range container.Resources.Requests {
+			if current, ok := requests[name]; !ok || quantity.Cmp(current) > 0 {
+				requests[name] = quantity.DeepCopy()
+			}
+		}
+	}
+
+	addResources(requests, pod.Spec.Overhead)
+	return requests
+}
+
+func addResources(total, add corev1.ResourceList) {
+	for name, quantity := range add {
+		current := total[name]
+		current.Add(quantity)
+		total[name] = current
+	}
+}
+
+// parkedRequests sums up the requests of all pods in a suspended namespace which are parked or about to be parked
+func parkedRequests(pods []corev1.Pod, state namespaceSuspendState) corev1.ResourceList {
+	requests := corev1.ResourceList{}
+	for _, pod := range pods {
+		if state.ignores(pod) {
+			continue
+		}
+
+		if isPodTerminated(pod) {
+			continue
+		}
+
+		addResources(requests, podRequests(pod))
+	}
+
+	return requests
+}
+
+// reportSavings exposes the requests of parked pods and the resource-hours saved since the namespace got suspended.
+// The savings are estimated using the current requests for the whole suspension.
+// The condition only reports the parked requests, a message changing with each resync would trigger a new reconciliation for every status update.
+// The suspended duration and the saved resource-hours are only exposed as metrics while the namespace is suspended.
+func (r *NamespaceReconciler) reportSavings(ctx context.Context, ns *corev1.Namespace, requests corev1.ResourceList) error {
+	now := time.Now()
+	since := now
+	if isNamespaceSuspended(*ns) {
+		since = getNamespaceCondition(*ns, conditionSuspended).LastTransitionTime.Time
+	}
+
+	cpu := requests.Cpu().AsApproximateFloat64()
+	memory := requests.Memory().AsApproximateFloat64()
+
+	parkedCPURequests.WithLabelValues(ns.Name).Set(cpu)
+	parkedMemoryRequests.WithLabelValues(ns.Name).Set(memory)
+	suspendedSeconds.WithLabelValues(ns.Name).Set(now.Sub(since).Seconds())
+
+	elapsed := r.savings.observe(ns.Name, since, now).Hours()
+	savedCPUHours.WithLabelValues(ns.Name).Add(cpu * elapsed)
+	savedMemoryHours.WithLabelValues(ns.Name).Add(memory / gibibyte * elapsed)
+
+	message := fmt.Sprintf(savingsMessage, formatQuantity(requests.Cpu()), formatQuantity(requests.Memory()))
+	return r.patchCondition(ctx, ns, conditionResourcesSaved, corev1.ConditionTrue, reasonSuspended, message)
+}
+
+// resetSavings stops reporting savings for a resumed namespace.
+// The condition keeps the totals of the last suspension, these are estimated from the parked requests reported by the condition
+// and the time since the condition turned true.
+func (r *NamespaceReconciler) resetSavings(ctx context.Context, ns *corev1.Namespace) error {
+	r.forgetSavings(ns.Name)
+
+	condition := getNamespaceCondition(*ns, conditionResourcesSaved)
+	if condition == nil || condition.Status != corev1.ConditionTrue {
+		return nil
+	}
+
+	message := condition.Message
+	var cpu, memory string
+	if _, err := fmt.Sscanf(condition.Message, savingsMessage, &cpu, &memory); err == nil {
+		cpuRequests, cpuErr := resource.ParseQuantity(cpu)
+		memoryRequests, memoryErr := resource.ParseQuantity(memory)
+		if cpuErr == nil && memoryErr == nil {
+			suspended := time.Since(condition.LastTransitionTime.Time).Round(time.Minute)
+			message = fmt.Sprintf("suspended for %s while parked pods requested %s CPU and %s memory, saved %.2f CPU core hours and %.2f memory GiB hours",
+				suspended, cpu, memory,
+				cpuRequests.AsApproximateFloat64()*suspended.Hours(),
+				memoryRequests.AsApproximateFloat64()/gibibyte*suspended.Hours())
+		}
+	}
+
+	return r.patchCondition(ctx, ns, conditionResourcesSaved, corev1.ConditionFalse, reasonResumed, message)
+}
+
+// forgetSavings removes the savings metrics of a namespace which is resumed or deleted
+func (r *NamespaceReconciler) forgetSavings(namespace string) {
+	r.savings.forget(namespace)
+	parkedCPURequests.DeleteLabelValues(namespace)
+	parkedMemoryRequests.DeleteLabelValues(namespace)
+	suspendedSeconds.DeleteLabelValues(namespace)
+}
+
+func formatQuantity(q *resource.Quantity) string {
+	if q.IsZero() {
+		return "0"
+	}
+
+	return q.String()
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestParkedRequests(t *testing.T) {
+	requests := func(cpu, memory string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse(cpu),
+				corev1.ResourceMemory: resource.MustParse(memory),
+			},
+		}
+	}
+
+	pods := []corev1.Pod{
+		{
+			Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Resources: requests("2", "64Mi")}},
+				Containers: []corev1.Container{
+					{Resources: requests("500m", "256Mi")},
+					{Resources: requests("500m", "256Mi")},
+				},
+				Overhead: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{ignoreAnnotation: "true"}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Resources: requests("1", "1Gi")}}},
+		},
+		{
+			Spec:   corev1.PodSpec{Containers: []corev1.Container{{Resources: requests("1", "1Gi")}}},
+			Status: corev1.PodStatus{Phase: corev1.PodSucceeded},
+		},
+		{
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Resources: requests("250m", "512Mi")}}},
+		},
+	}
+
+	total := parkedRequests(pods, namespaceSuspendState{Suspend: true})
+	if cpu := total.Cpu(); cpu.Cmp(resource.MustParse("2250m")) != 0 {
+		t.Errorf("expected 2250m CPU, got %s", cpu)
+	}
+
+	if memory := total.Memory(); memory.Cmp(resource.MustParse("1088Mi")) != 0 {
+		t.Errorf("expected 1088Mi memory, got %s", memory)
+	}
+}
+
+func TestSavingsObserver(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	var observer savingsObserver
+
+	// time before the controller started is not accounted again
+	if elapsed := observer.observe("a", start.Add(-time.Hour), start); elapsed != 0 {
+		t.Errorf("expected nothing to be accounted on the first observation, got %s", elapsed)
+	}
+
+	if elapsed := observer.observe("a", start.Add(-time.Hour), start.Add(time.Hour)); elapsed != time.Hour {
+		t.Errorf("expected 1h since the last observation, got %s", elapsed)
+	}
+
+	if elapsed := observer.observe("b", start.Add(30*time.Minute), start.Add(time.Hour)); elapsed != 30*time.Minute {
+		t.Errorf("expected 30m since the suspension started, got %s", elapsed)
+	}
+}
+
+func TestReportSavings(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "staging"}}
+	setNamespaceCondition(ns, conditionSuspended, corev1.ConditionTrue, reasonSuspended, "all pods are suspended")
+
+	r := newTestNamespaceReconciler(t, NamespaceReconcilerOptions{}, ns)
+	requests := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("2"),
+		corev1.ResourceMemory: resource.MustParse("1Gi"),
+	}
+
+	if err := r.reportSavings(context.TODO(), ns, requests); err != nil {
+		t.Fatal(err)
+	}
+
+	var reported corev1.Namespace
+	if err := r.Client.Get(context.TODO(), client.ObjectKey{Name: "staging"}, &reported); err != nil {
+		t.Fatal(err)
+	}
+
+	condition := getNamespaceCondition(reported, conditionResourcesSaved)
+	if condition == nil || condition.Message != "parked pods request 2 CPU and 1Gi memory" {
+		t.Fatalf("expected parked requests to be reported, got %v", condition)
+	}
+
+	// The status is not updated again as long as the parked requests do not change
+	if err := r.reportSavings(context.TODO(), &reported, requests); err != nil {
+		t.Fatal(err)
+	}
+
+	var resynced corev1.Namespace
+	if err := r.Client.Get(context.TODO(), client.ObjectKey{Name: "staging"}, &resynced); err != nil {
+		t.Fatal(err)
+	}
+
+	if resynced.ResourceVersion != reported.ResourceVersion {
+		t.Errorf("expected the namespace not to be updated on resync, got resource version %s instead of %s", resynced.ResourceVersion, reported.ResourceVersion)
+	}
+}
+
+func TestResetSavings(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "staging"}}
+	setNamespaceCondition(ns, conditionResourcesSaved, corev1.ConditionTrue, reasonSuspended, "parked pods request 2 CPU and 512Mi memory")
+	ns.Status.Conditions[0].LastTransitionTime = metav1.NewTime(time.Now().Add(-3 * time.Hour))
+
+	r := newTestNamespaceReconciler(t, NamespaceReconcilerOptions{}, ns)
+	parkedCPURequests.WithLabelValues("staging").Set(2)
+
+	if err := r.resetSavings(context.TODO(), ns); err != nil {
+		t.Fatal(err)
+	}
+
+	var reported corev1.Namespace
+	if err := r.Client.Get(context.TODO(), client.ObjectKey{Name: "staging"}, &reported); err != nil {
+		t.Fatal(err)
+	}
+
+	condition := getNamespaceCondition(reported, conditionResourcesSaved)
+	expected := "suspended for 3h0m0s while parked pods requested 2 CPU and 512Mi memory, saved 6.00 CPU core hours and 1.50 memory GiB hours"
+	if condition == nil || condition.Status != corev1.ConditionFalse || condition.Message != expected {
+		t.Fatalf("expected the totals to be reported, got %v", condition)
+	}
+
+	if parkedCPURequests.DeleteLabelValues("staging") {
+		t.Error("expected the parked requests metric to be removed")
+	}
+}
+
+func TestForgetSavingsOfDeletedNamespace(t *testing.T) {
+	r := newTestNamespaceReconciler(t, NamespaceReconcilerOptions{})
+	parkedCPURequests.WithLabelValues("deleted").Set(2)
+	parkedMemoryRequests.WithLabelValues("deleted").Set(gibibyte)
+	suspendedSeconds.WithLabelValues("deleted").Set(60)
+
+	if _, err := r.Reconcile(context.TODO(), ctrl.Request{NamespacedName: client.ObjectKey{Name: "deleted"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if parkedCPURequests.DeleteLabelValues("deleted") || parkedMemoryRequests.DeleteLabelValues("deleted") || suspendedSeconds.DeleteLabelValues("deleted") {
+		t.Error("expected the savings metrics of the deleted namespace to be removed")
+	}
+}
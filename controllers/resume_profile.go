@@ -0,0 +1,311 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// patternCacheSize bounds the number of cached regular expressions.
+// Patterns of changed or deleted profiles are never evicted individually, the cache is cleared once it is full.
+const patternCacheSize = 1024
+
+// patterns caches compiled regular expressions of resume profiles, the webhook evaluates them for each pod
+var patterns = patternCache{size: patternCacheSize}
+
+type patternCache struct {
+	mu       sync.Mutex
+	size     int
+	compiled map[string]*regexp.Regexp
+}
+
+// profileLookup fetches a ResumeProfile, it allows the reconcilers and the webhook to resolve profiles from their own source
+type profileLookup func(ctx context.Context, key client.ObjectKey) (*v1beta1.ResumeProfile, error)
+
+// resolvedProfile is one or more ResumeProfiles flattened together with all the profiles they include
+type resolvedProfile struct {
+	Name      string
+	Selectors []v1beta1.ResolvedSelectors
+
+	// Skipped holds the includes which were ignored since they do not exist or form a cycle
+	Skipped []error
+}
+
+// includeCycleError is returned if a profile includes itself directly or indirectly
+type includeCycleError struct {
+	path []string
+}
+
+func (e includeCycleError) Error() string {
+	return fmt.Sprintf("include cycle detected: %s", strings.Join(e.path, " -> "))
+}
+
+// profileNames splits the comma separated list of active profiles, empty and duplicate names are dropped
+func profileNames(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		duplicate := false
+		for _, existing := range names {
+			if existing == name {
+				duplicate = true
+				break
+			}
+		}
+
+		if !duplicate {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// resolveResumeProfiles resolves all active profiles of a namespace into their union, a pod may run if it is matched by any of them.
+// The exclude selectors of a profile only apply to the profile itself and its includes, not to the other active profiles.
+func resolveResumeProfiles(ctx context.Context, lookup profileLookup, namespace string, names []string) (resolvedProfile, error) {
+	union := resolvedProfile{Name: strings.Join(names, ", ")}
+	for _, name := range names {
+		profile, err := lookup(ctx, client.ObjectKey{Name: name, Namespace: namespace})
+		if err != nil {
+			return resolvedProfile{}, err
+		}
+
+		resolved, err := resolveResumeProfile(ctx, lookup, *profile)
+		if err != nil {
+			return resolvedProfile{}, err
+		}
+
+		union.Selectors = append(union.Selectors, resolved.Selectors...)
+		union.Skipped = append(union.Skipped, resolved.Skipped...)
+	}
+
+	return union, nil
+}
+
+// String describes the profile for messages
+func (p resolvedProfile) String() string {
+	if strings.Contains(p.Name, ",") {
+		return fmt.Sprintf("ResumeProfiles %s", p.Name)
+	}
+
+	return fmt.Sprintf("ResumeProfile %s", p.Name)
+}
+
+// resolveResumeProfile flattens a profile and all the profiles it includes.
+// The exclude selectors of a profile take precedence over its includes, therefore they are applied to all included selectors.
+// Includes which do not exist or form a cycle are skipped and reported in Skipped, a broken include must not block the admission of pods.
+func resolveResumeProfile(ctx context.Context, lookup profileLookup, profile v1beta1.ResumeProfile) (resolvedProfile, error) {
+	resolved := resolvedProfile{Name: profile.Name}
+	selectors, err := resolveIncludes(ctx, lookup, profile, nil, []string{profile.Name}, &resolved.Skipped)
+	resolved.Selectors = selectors
+	return resolved, err
+}
+
+func resolveIncludes(ctx context.Context, lookup profileLookup, profile v1beta1.ResumeProfile, excludes []metav1.LabelSelector, path []string, skipped *[]error) ([]v1beta1.ResolvedSelectors, error) {
+	excludes = append(append([]metav1.LabelSelector{}, excludes...), profile.Spec.ExcludeSelector...)
+
+	own := *profile.Spec.ProfileSelectors.DeepCopy()
+	own.ExcludeSelector = excludes
+	resolved := []v1beta1.ResolvedSelectors{{
+		Profile:          profile.Name,
+		ProfileSelectors: own,
+		Overrides:        profile.Spec.Overrides,
+	}}
+
+includes:
+	for _, name := range profile.Spec.Includes {
+		for _, visited := range path {
+			if visited == name {
+				*skipped = append(*skipped, includeCycleError{path: append(append([]string{}, path...), name)})
+				continue includes
+			}
+		}
+
+		included, err := lookup(ctx, client.ObjectKey{Name: name, Namespace: profile.Namespace})
+		if apierrors.IsNotFound(err) {
+			*skipped = append(*skipped, fmt.Errorf("included profile %s: %w", name, err))
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve included profile %s: %w", name, err)
+		}
+
+		selectors, err := resolveIncludes(ctx, lookup, *included, excludes, append(path[:len(path):len(path)], name), skipped)
+		if err != nil {
+			return nil, err
+		}
+
+		resolved = append(resolved, selectors...)
+	}
+
+	return resolved, nil
+}
+
+// matchesResumeProfile returns true if the pod is matched by the profile or any of the profiles it includes
+func matchesResumeProfile(pod corev1.Pod, profile resolvedProfile) bool {
+	for _, selectors := range profile.Selectors {
+		if matchesSelectors(pod, selectors.ProfileSelectors) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesSelectors returns true if the pod is matched by any of the selectors and not excluded
+func matchesSelectors(pod corev1.Pod, selectors v1beta1.ProfileSelectors) bool {
+	if matchesLabelSelectors(pod, selectors.ExcludeSelector) {
+		return false
+	}
+
+	if matchesLabelSelectors(pod, selectors.PodSelector) {
+		return true
+	}
+
+	if len(selectors.Workloads) > 0 {
+		kind, name := podWorkload(pod)
+		for _, workload := range selectors.Workloads {
+			if workload.Kind == kind && workload.Name == name {
+				return true
+			}
+		}
+	}
+
+	for _, image := range selectors.Images {
+		re, err := compilePattern(globToRegexp(image))
+		if err != nil {
+			continue
+		}
+
+		for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+			for _, container := range containers {
+				if re.MatchString(container.Image) {
+					return true
+				}
+			}
+		}
+	}
+
+	name := podNamePrefix(pod)
+	for _, pattern := range selectors.PodNames {
+		re, err := compilePattern(pattern)
+		if err != nil {
+			continue
+		}
+
+		if re.MatchString(name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// podNamePrefix returns the name pod name patterns are matched against.
+// Pods created by a controller have no name yet while they are admitted by the webhook, their generateName is used instead.
+// The controller must match the same value, otherwise a pod admitted as parked would be resumed and parked again forever.
+func podNamePrefix(pod corev1.Pod) string {
+	if pod.GenerateName != "" {
+		return pod.GenerateName
+	}
+
+	return pod.Name
+}
+
+// matchesLabelSelectors returns true if the pod labels match any of the selectors, invalid selectors match no pods
+func matchesLabelSelectors(pod corev1.Pod, selectors []metav1.LabelSelector) bool {
+	for _, match := range selectors {
+		selector, err := metav1.LabelSelectorAsSelector(&match)
+		if err != nil {
+			continue
+		}
+
+		if selector.Matches(labels.Set(pod.Labels)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// podWorkload returns the kind and name of the controller owning the pod.
+// Pods owned by a ReplicaSet which is part of a Deployment are attributed to the Deployment.
+func podWorkload(pod corev1.Pod) (kind, name string) {
+	owner := metav1.GetControllerOf(&pod)
+	if owner == nil {
+		return "", ""
+	}
+
+	if owner.Kind == "ReplicaSet" {
+		if hash := pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey]; hash != "" && strings.HasSuffix(owner.Name, "-"+hash) {
+			return "Deployment", strings.TrimSuffix(owner.Name, "-"+hash)
+		}
+	}
+
+	return owner.Kind, owner.Name
+}
+
+// globToRegexp converts a glob pattern into an anchored regular expression
+func globToRegexp(glob string) string {
+	pattern := regexp.QuoteMeta(glob)
+	pattern = strings.ReplaceAll(pattern, `\*`, ".*")
+	pattern = strings.ReplaceAll(pattern, `\?`, ".")
+	return "^" + pattern + "$"
+}
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	return patterns.compile(pattern)
+}
+
+func (c *patternCache) compile(pattern string) (*regexp.Regexp, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if re, ok := c.compiled[pattern]; ok {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.compiled == nil || len(c.compiled) >= c.size {
+		c.compiled = make(map[string]*regexp.Regexp)
+	}
+
+	c.compiled[pattern] = re
+	return re, nil
+}
@@ -0,0 +1,317 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestMatchesResumeProfile(t *testing.T) {
+	controller := true
+	owned := func(kind, name string, labels map[string]string) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: name + "-",
+				Labels:       labels,
+				OwnerReferences: []metav1.OwnerReference{
+					{Kind: kind, Name: name, Controller: &controller},
+				},
+			},
+		}
+	}
+
+	// created sets the name the API server generated after admission
+	created := func(pod corev1.Pod, name string) corev1.Pod {
+		pod.Name = name
+		return pod
+	}
+
+	withImage := func(image string) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod"},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Image: image}}},
+		}
+	}
+
+	for _, test := range []struct {
+		name      string
+		selectors v1beta1.ProfileSelectors
+		pod       corev1.Pod
+		matches   bool
+	}{
+		{
+			name:      "label selector",
+			selectors: v1beta1.ProfileSelectors{PodSelector: []metav1.LabelSelector{{MatchLabels: map[string]string{"app": "api"}}}},
+			pod:       corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "api"}}},
+			matches:   true,
+		},
+		{
+			name: "excluded by label",
+			selectors: v1beta1.ProfileSelectors{
+				PodSelector:     []metav1.LabelSelector{{MatchLabels: map[string]string{"app": "garden"}}},
+				ExcludeSelector: []metav1.LabelSelector{{MatchLabels: map[string]string{"component": "worker"}}},
+			},
+			pod:     corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "garden", "component": "worker"}}},
+			matches: false,
+		},
+		{
+			name: "exclusion takes precedence over workloads",
+			selectors: v1beta1.ProfileSelectors{
+				Workloads:       []v1beta1.WorkloadSelector{{Kind: "StatefulSet", Name: "postgres"}},
+				ExcludeSelector: []metav1.LabelSelector{{MatchLabels: map[string]string{"role": "replica"}}},
+			},
+			pod:     owned("StatefulSet", "postgres", map[string]string{"role": "replica"}),
+			matches: false,
+		},
+		{
+			name:      "deployment",
+			selectors: v1beta1.ProfileSelectors{Workloads: []v1beta1.WorkloadSelector{{Kind: "Deployment", Name: "api"}}},
+			pod:       owned("ReplicaSet", "api-5d8f9c7b4", map[string]string{"pod-template-hash": "5d8f9c7b4"}),
+			matches:   true,
+		},
+		{
+			name:      "replicaset without deployment",
+			selectors: v1beta1.ProfileSelectors{Workloads: []v1beta1.WorkloadSelector{{Kind: "Deployment", Name: "api"}}},
+			pod:       owned("ReplicaSet", "api", nil),
+			matches:   false,
+		},
+		{
+			name:      "statefulset",
+			selectors: v1beta1.ProfileSelectors{Workloads: []v1beta1.WorkloadSelector{{Kind: "StatefulSet", Name: "postgres"}}},
+			pod:       owned("StatefulSet", "postgres", nil),
+			matches:   true,
+		},
+		{
+			name:      "image glob",
+			selectors: v1beta1.ProfileSelectors{Images: []string{"docker.io/library/postgres:*"}},
+			pod:       withImage("docker.io/library/postgres:15.2"),
+			matches:   true,
+		},
+		{
+			name:      "image glob is anchored",
+			selectors: v1beta1.ProfileSelectors{Images: []string{"postgres:*"}},
+			pod:       withImage("docker.io/library/postgres:15.2"),
+			matches:   false,
+		},
+		{
+			name:      "pod name",
+			selectors: v1beta1.ProfileSelectors{PodNames: []string{"^api-"}},
+			pod:       owned("StatefulSet", "api", nil),
+			matches:   true,
+		},
+		{
+			name:      "pod name of created pod matches the generate name",
+			selectors: v1beta1.ProfileSelectors{PodNames: []string{"^api-$"}},
+			pod:       created(owned("StatefulSet", "api", nil), "api-x7k2p"),
+			matches:   true,
+		},
+		{
+			name:      "random suffix of created pod is not matched",
+			selectors: v1beta1.ProfileSelectors{PodNames: []string{"^api-x7k2p$"}},
+			pod:       created(owned("StatefulSet", "api", nil), "api-x7k2p"),
+			matches:   false,
+		},
+		{
+			name:      "pod name without generate name",
+			selectors: v1beta1.ProfileSelectors{PodNames: []string{"^migration$"}},
+			pod:       corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "migration"}},
+			matches:   true,
+		},
+		{
+			name:      "invalid pod name pattern",
+			selectors: v1beta1.ProfileSelectors{PodNames: []string{"("}},
+			pod:       withImage("nginx"),
+			matches:   false,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			profile := v1beta1.ResumeProfile{Spec: v1beta1.ResumeProfileSpec{ProfileSelectors: test.selectors}}
+			resolved, err := resolveResumeProfile(context.TODO(), nil, profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if matches := matchesResumeProfile(test.pod, resolved); matches != test.matches {
+				t.Errorf("expected match to be %v, got %v", test.matches, matches)
+			}
+		})
+	}
+}
+
+func TestPatternCache(t *testing.T) {
+	cache := patternCache{size: 2}
+	for _, pattern := range []string{"^a", "^b", "^c"} {
+		if _, err := cache.compile(pattern); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(cache.compiled) > 2 {
+		t.Errorf("expected at most 2 cached patterns, got %d", len(cache.compiled))
+	}
+
+	if re, err := cache.compile("^c"); err != nil || !re.MatchString("cache") {
+		t.Errorf("expected cached pattern to match, got %v", err)
+	}
+
+	if _, err := cache.compile("("); err == nil {
+		t.Error("expected invalid pattern to fail")
+	}
+}
+
+func TestResolveResumeProfile(t *testing.T) {
+	profile := func(name string, labels map[string]string, exclude map[string]string, includes ...string) v1beta1.ResumeProfile {
+		p := v1beta1.ResumeProfile{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "staging"},
+			Spec:       v1beta1.ResumeProfileSpec{Includes: includes},
+		}
+
+		if labels != nil {
+			p.Spec.PodSelector = []metav1.LabelSelector{{MatchLabels: labels}}
+		}
+
+		if exclude != nil {
+			p.Spec.ExcludeSelector = []metav1.LabelSelector{{MatchLabels: exclude}}
+		}
+
+		return p
+	}
+
+	profiles := map[string]v1beta1.ResumeProfile{
+		"frontend": profile("frontend", map[string]string{"app": "web"}, nil, "backend"),
+		"backend":  profile("backend", map[string]string{"app": "api"}, nil, "database"),
+		"database": profile("database", map[string]string{"app": "postgres"}, nil),
+		"no-jobs":  profile("no-jobs", nil, map[string]string{"component": "job"}, "backend"),
+		"cycle-a":  profile("cycle-a", nil, nil, "cycle-b"),
+		"cycle-b":  profile("cycle-b", nil, nil, "cycle-a"),
+		"missing":  profile("missing", nil, nil, "does-not-exist"),
+	}
+
+	lookup := func(ctx context.Context, key client.ObjectKey) (*v1beta1.ResumeProfile, error) {
+		p, ok := profiles[key.Name]
+		if !ok {
+			return nil, apierrors.NewNotFound(v1beta1.GroupVersion.WithResource("resumeprofiles").GroupResource(), key.Name)
+		}
+
+		return &p, nil
+	}
+
+	pod := func(labels map[string]string) corev1.Pod {
+		return corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Labels: labels}}
+	}
+
+	resolved, err := resolveResumeProfile(context.TODO(), lookup, profiles["frontend"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resolved.Selectors) != 3 {
+		t.Fatalf("expected 3 resolved selectors, got %d", len(resolved.Selectors))
+	}
+
+	if !matchesResumeProfile(pod(map[string]string{"app": "postgres"}), resolved) {
+		t.Error("expected pod of transitively included profile to match")
+	}
+
+	resolved, err = resolveResumeProfile(context.TODO(), lookup, profiles["no-jobs"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if matchesResumeProfile(pod(map[string]string{"app": "postgres", "component": "job"}), resolved) {
+		t.Error("expected exclusion to apply to included profiles")
+	}
+
+	if !matchesResumeProfile(pod(map[string]string{"app": "api"}), resolved) {
+		t.Error("expected pod of included profile to match")
+	}
+
+	// Broken includes are skipped, the profile itself still applies
+	var cycle includeCycleError
+	resolved, err = resolveResumeProfile(context.TODO(), lookup, profiles["cycle-a"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resolved.Selectors) != 2 || len(resolved.Skipped) != 1 || !errors.As(resolved.Skipped[0], &cycle) {
+		t.Errorf("expected the include closing the cycle to be skipped, got %v and %v", resolved.Selectors, resolved.Skipped)
+	}
+
+	resolved, err = resolveResumeProfile(context.TODO(), lookup, profiles["missing"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(resolved.Selectors) != 1 || len(resolved.Skipped) != 1 || !apierrors.IsNotFound(resolved.Skipped[0]) {
+		t.Errorf("expected the missing include to be skipped, got %v and %v", resolved.Selectors, resolved.Skipped)
+	}
+
+	failing := func(ctx context.Context, key client.ObjectKey) (*v1beta1.ResumeProfile, error) {
+		if key.Name == "frontend" {
+			return lookup(ctx, key)
+		}
+
+		return nil, errors.New("unavailable")
+	}
+
+	if _, err := resolveResumeProfile(context.TODO(), failing, profiles["frontend"]); err == nil {
+		t.Error("expected a failed lookup of an include to fail")
+	}
+
+	union, err := resolveResumeProfiles(context.TODO(), lookup, "staging", []string{"database", "no-jobs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !matchesResumeProfile(pod(map[string]string{"app": "postgres", "component": "job"}), union) {
+		t.Error("expected exclusion of one profile not to apply to the other active profiles")
+	}
+
+	if matchesResumeProfile(pod(map[string]string{"app": "web"}), union) {
+		t.Error("expected pod not matched by any active profile not to match")
+	}
+
+	if union.String() != "ResumeProfiles database, no-jobs" {
+		t.Errorf("unexpected description %s", union)
+	}
+
+	if _, err := resolveResumeProfiles(context.TODO(), lookup, "staging", []string{"database", "does-not-exist"}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestProfileNames(t *testing.T) {
+	for value, expected := range map[string][]string{
+		"":                       nil,
+		"backend":                {"backend"},
+		" team-a , team-b,":      {"team-a", "team-b"},
+		"team-a,team-b,team-a,,": {"team-a", "team-b"},
+	} {
+		if names := profileNames(value); strings.Join(names, ",") != strings.Join(expected, ",") || len(names) != len(expected) {
+			t.Errorf("expected %v for %q, got %v", expected, value, names)
+		}
+	}
+}
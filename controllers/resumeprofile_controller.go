@@ -0,0 +1,157 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+//+kubebuilder:rbac:groups=pause.infra.doodle.com,resources=resumeprofiles/status,verbs=get;update;patch
+
+const (
+	// conditionReady reports whether a ResumeProfile and all its includes could be resolved
+	conditionReady = "Ready"
+
+	reasonResolved        = "Resolved"
+	reasonIncludeCycle    = "IncludeCycle"
+	reasonResolveFailed   = "ResolveFailed"
+	reasonIncludeNotFound = "IncludeNotFound"
+	reasonInvalidOverride = "InvalidOverride"
+)
+
+// ResumeProfileReconciler reports the flattened view of a ResumeProfile in its status
+type ResumeProfileReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+type ResumeProfileReconcilerOptions struct {
+	MaxConcurrentReconciles int
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ResumeProfileReconciler) SetupWithManager(mgr ctrl.Manager, opts ResumeProfileReconcilerOptions) error {
+	// ResumeProfiles are watched once, a changed profile enqueues itself and all profiles which may include it
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("resumeprofile").
+		Watches(
+			&source.Kind{Type: &v1beta1.ResumeProfile{}},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForNamespaceProfiles),
+		).
+		WithOptions(controller.Options{MaxConcurrentReconciles: opts.MaxConcurrentReconciles}).
+		Complete(r)
+}
+
+// requestsForNamespaceProfiles enqueues the changed profile and all profiles in its namespace with includes since any of them may include it
+func (r *ResumeProfileReconciler) requestsForNamespaceProfiles(obj client.Object) []reconcile.Request {
+	reqs := []reconcile.Request{{NamespacedName: client.ObjectKeyFromObject(obj)}}
+
+	var list v1beta1.ResumeProfileList
+	if err := r.Client.List(context.TODO(), &list, client.InNamespace(obj.GetNamespace())); err != nil {
+		return reqs
+	}
+
+	for _, profile := range list.Items {
+		if profile.Name != obj.GetName() && len(profile.Spec.Includes) > 0 {
+			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&profile)})
+		}
+	}
+
+	return reqs
+}
+
+// Reconcile resolves a ResumeProfile and reports the result in its status
+func (r *ResumeProfileReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := r.Log.WithValues("Namespace", req.Namespace, "Name", req.Name)
+
+	profile := v1beta1.ResumeProfile{}
+	err := r.Client.Get(ctx, req.NamespacedName, &profile)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+
+		return reconcile.Result{}, err
+	}
+
+	updated := profile.DeepCopy()
+	updated.Status.ObservedGeneration = profile.Generation
+
+	resolved, err := resolveResumeProfile(ctx, r.getResumeProfile, profile)
+	condition := metav1.Condition{
+		Type:               conditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             reasonResolved,
+		Message:            "profile and all includes are resolved",
+		ObservedGeneration: profile.Generation,
+	}
+
+	// Broken includes are skipped when the profile is applied, the profile is still reported as not ready.
+	// The reason is taken from the first skipped include.
+	cause := err
+	if err == nil && len(resolved.Skipped) > 0 {
+		cause, err = resolved.Skipped[0], utilerrors.NewAggregate(resolved.Skipped)
+	}
+
+	var cycle includeCycleError
+	invalid := validateOverrides(resolved)
+
+	switch {
+	case errors.As(cause, &cycle):
+		condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, reasonIncludeCycle, err.Error()
+	case apierrors.IsNotFound(cause):
+		condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, reasonIncludeNotFound, err.Error()
+	case err != nil:
+		condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, reasonResolveFailed, err.Error()
+	case invalid != nil:
+		condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, reasonInvalidOverride, invalid.Error()
+	}
+
+	if err != nil {
+		logger.Info("failed to resolve resume profile", "error", err.Error())
+	}
+
+	updated.Status.Resolved = resolved.Selectors
+
+	meta.SetStatusCondition(&updated.Status.Conditions, condition)
+	return ctrl.Result{}, r.Client.Status().Patch(ctx, updated, client.MergeFrom(&profile))
+}
+
+func (r *ResumeProfileReconciler) getResumeProfile(ctx context.Context, key client.ObjectKey) (*v1beta1.ResumeProfile, error) {
+	var profile v1beta1.ResumeProfile
+	if err := r.Client.Get(ctx, key, &profile); err != nil {
+		return nil, err
+	}
+
+	return &profile, nil
+}
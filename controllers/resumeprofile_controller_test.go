@@ -0,0 +1,127 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestResumeProfileReconcile(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	profile := func(name string, includes ...string) *v1beta1.ResumeProfile {
+		return &v1beta1.ResumeProfile{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "staging"},
+			Spec: v1beta1.ResumeProfileSpec{
+				Includes: includes,
+				ProfileSelectors: v1beta1.ProfileSelectors{
+					PodSelector: []metav1.LabelSelector{{MatchLabels: map[string]string{"app": name}}},
+				},
+			},
+		}
+	}
+
+	invalid := profile("invalid-override")
+	invalid.Spec.Overrides = []v1beta1.WorkloadOverride{
+		{Selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "tier", Operator: "Bogus"}}}},
+	}
+
+	for _, test := range []struct {
+		name     string
+		profile  string
+		status   metav1.ConditionStatus
+		reason   string
+		resolved int
+	}{
+		{name: "resolved", profile: "backend", status: metav1.ConditionTrue, reason: reasonResolved, resolved: 2},
+		{name: "missing include is skipped", profile: "missing", status: metav1.ConditionFalse, reason: reasonIncludeNotFound, resolved: 1},
+		{name: "include cycle is skipped", profile: "cycle-a", status: metav1.ConditionFalse, reason: reasonIncludeCycle, resolved: 2},
+		{name: "invalid override selector", profile: "invalid-override", status: metav1.ConditionFalse, reason: reasonInvalidOverride, resolved: 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := &ResumeProfileReconciler{
+				Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+					profile("backend", "database"),
+					profile("database"),
+					profile("missing", "does-not-exist"),
+					profile("cycle-a", "cycle-b"),
+					profile("cycle-b", "cycle-a"),
+					invalid,
+				).Build(),
+				Log:    logr.Discard(),
+				Scheme: scheme,
+			}
+
+			req := ctrl.Request{NamespacedName: client.ObjectKey{Name: test.profile, Namespace: "staging"}}
+			if _, err := r.Reconcile(context.TODO(), req); err != nil {
+				t.Fatal(err)
+			}
+
+			var updated v1beta1.ResumeProfile
+			if err := r.Client.Get(context.TODO(), req.NamespacedName, &updated); err != nil {
+				t.Fatal(err)
+			}
+
+			condition := meta.FindStatusCondition(updated.Status.Conditions, conditionReady)
+			if condition == nil || condition.Status != test.status || condition.Reason != test.reason {
+				t.Errorf("expected condition %s/%s, got %v", test.status, test.reason, condition)
+			}
+
+			if len(updated.Status.Resolved) != test.resolved {
+				t.Errorf("expected %d resolved selectors, got %v", test.resolved, updated.Status.Resolved)
+			}
+		})
+	}
+}
+
+func TestResumeProfileRequestsForNamespaceProfiles(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &ResumeProfileReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&v1beta1.ResumeProfile{ObjectMeta: metav1.ObjectMeta{Name: "database", Namespace: "staging"}},
+			&v1beta1.ResumeProfile{ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "staging"}, Spec: v1beta1.ResumeProfileSpec{Includes: []string{"database"}}},
+			&v1beta1.ResumeProfile{ObjectMeta: metav1.ObjectMeta{Name: "frontend", Namespace: "staging"}},
+		).Build(),
+	}
+
+	reqs := r.requestsForNamespaceProfiles(&v1beta1.ResumeProfile{ObjectMeta: metav1.ObjectMeta{Name: "database", Namespace: "staging"}})
+	if len(reqs) != 2 || reqs[0].Name != "database" || reqs[1].Name != "backend" {
+		t.Errorf("expected the changed profile and the profile with includes to be enqueued, got %v", reqs)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get
+
+const (
+	previousSchedulingState = "k8s-pause/previousSchedulingState"
+)
+
+// schedulingState holds the scheduling related fields of an unowned pod as they were before the pod got suspended.
+// It is stored as json in the previousSchedulingState annotation of the suspended clone.
+//
+// The following policy is applied once the pod gets resumed:
+// * nodeName is restored if the node still exists and is schedulable and no scheduling gate is restored, otherwise it is dropped and the pod goes through the scheduler, the API server rejects pods with both a nodeName and scheduling gates.
+// * schedulingGates are restored if they are still present on the suspended pod, gates lifted while suspended stay lifted
+// * priority is restored if the pod has no priorityClassName, otherwise it is dropped and resolved again by the API server
+// * tolerations are restored as recorded, this drops duplicates added by admission webhooks while the pod was recreated
+//
+// Pods parked by the scheduling gate are resumed in place instead, the spec of an existing pod is immutable except for lifting gates.
+// The recorded state is dropped in this case and the pod goes through the scheduler with the spec it was parked with.
+type schedulingState struct {
+	NodeName        string                     `json:"nodeName,omitempty"`
+	SchedulingGates []corev1.PodSchedulingGate `json:"schedulingGates,omitempty"`
+	Priority        *int32                     `json:"priority,omitempty"`
+	Tolerations     []corev1.Toleration        `json:"tolerations,omitempty"`
+}
+
+// recordSchedulingState stores the scheduling state of pod on clone
+func recordSchedulingState(pod corev1.Pod, clone *corev1.Pod) error {
+	state := schedulingState{
+		NodeName:        pod.Spec.NodeName,
+		SchedulingGates: pod.Spec.SchedulingGates,
+		Priority:        pod.Spec.Priority,
+		Tolerations:     pod.Spec.Tolerations,
+	}
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode scheduling state: %w", err)
+	}
+
+	if clone.Annotations == nil {
+		clone.Annotations = make(map[string]string)
+	}
+
+	clone.Annotations[previousSchedulerName] = originalSchedulerName(pod)
+	clone.Annotations[previousSchedulingState] = string(b)
+	return nil
+}
+
+// restoreSchedulingState restores the recorded scheduling state on clone according to the policy documented on schedulingState.
+// The recorded state is only removed from clone once it has been restored, an error leaves clone untouched so the resume can be retried.
+func restoreSchedulingState(ctx context.Context, c client.Client, clone *corev1.Pod) error {
+	raw, ok := clone.Annotations[previousSchedulingState]
+	if !ok {
+		clone.Spec.SchedulerName = originalSchedulerName(*clone)
+		delete(clone.Annotations, previousSchedulerName)
+		return nil
+	}
+
+	var state schedulingState
+	if err := json.Unmarshal([]byte(raw), &state); err != nil {
+		return fmt.Errorf("failed to decode scheduling state: %w", err)
+	}
+
+	var nodeName string
+	if state.NodeName != "" {
+		schedulable, err := nodeSchedulable(ctx, c, state.NodeName)
+		if err != nil {
+			return err
+		}
+
+		if schedulable {
+			nodeName = state.NodeName
+		}
+	}
+
+	var gates []corev1.PodSchedulingGate
+	for _, gate := range state.SchedulingGates {
+		if hasSchedulingGate(clone, gate.Name) {
+			gates = append(gates, gate)
+		}
+	}
+
+	// A pod which is still gated must be placed by the scheduler once its gates are lifted
+	if len(gates) > 0 {
+		nodeName = ""
+	}
+
+	clone.Spec.SchedulerName = originalSchedulerName(*clone)
+	clone.Spec.NodeName = nodeName
+	clone.Spec.SchedulingGates = gates
+
+	if clone.Spec.PriorityClassName == "" {
+		clone.Spec.Priority = state.Priority
+	} else {
+		clone.Spec.Priority = nil
+	}
+
+	clone.Spec.Tolerations = state.Tolerations
+
+	delete(clone.Annotations, previousSchedulerName)
+	delete(clone.Annotations, previousSchedulingState)
+	return nil
+}
+
+// originalSchedulerName returns the scheduler which is supposed to schedule the pod once it is resumed.
+// This is the current scheduler unless the pod is parked by the k8s-pause scheduler, in which case the recorded previous scheduler is used.
+// The default scheduler is used as fallback.
+func originalSchedulerName(pod corev1.Pod) string {
+	if pod.Spec.SchedulerName != "" && pod.Spec.SchedulerName != schedulerName {
+		return pod.Spec.SchedulerName
+	}
+
+	if scheduler := pod.Annotations[previousSchedulerName]; scheduler != "" && scheduler != schedulerName {
+		return scheduler
+	}
+
+	return corev1.DefaultSchedulerName
+}
+
+func nodeSchedulable(ctx context.Context, c client.Client, name string) (bool, error) {
+	var node corev1.Node
+	err := c.Get(ctx, client.ObjectKey{Name: name}, &node)
+
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("failed to lookup node %s: %w", name, err)
+	}
+
+	return !node.Spec.Unschedulable, nil
+}
+
+func hasSchedulingGate(pod *corev1.Pod, name string) bool {
+	for _, gate := range pod.Spec.SchedulingGates {
+		if gate.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// failingGetClient fails every Get call
+type failingGetClient struct {
+	client.Client
+}
+
+func (c failingGetClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	return errors.New("unavailable")
+}
+
+func TestRestoreSchedulingState(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	priority := int32(100)
+	original := corev1.Pod{
+		Spec: corev1.PodSpec{
+			SchedulerName:   "custom",
+			NodeName:        "node-a",
+			SchedulingGates: []corev1.PodSchedulingGate{{Name: "kept"}, {Name: "lifted"}},
+			Priority:        &priority,
+			Tolerations:     []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpExists}},
+		},
+	}
+
+	// suspended creates the clone of the original pod, gates are the gates still present while suspended
+	suspended := func(gates []corev1.PodSchedulingGate) *corev1.Pod {
+		clone := original.DeepCopy()
+		if err := recordSchedulingState(original, clone); err != nil {
+			t.Fatal(err)
+		}
+
+		clone.Spec.SchedulerName = schedulerName
+		clone.Spec.NodeName = ""
+		clone.Spec.SchedulingGates = gates
+		clone.Spec.Tolerations = append(clone.Spec.Tolerations, clone.Spec.Tolerations...)
+		return clone
+	}
+
+	node := func(name string, unschedulable bool) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       corev1.NodeSpec{Unschedulable: unschedulable},
+		}
+	}
+
+	kept := []corev1.PodSchedulingGate{{Name: "kept"}}
+
+	for _, test := range []struct {
+		name     string
+		client   client.Client
+		gates    []corev1.PodSchedulingGate
+		nodeName string
+		err      bool
+	}{
+		{name: "schedulable node", client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(node("node-a", false)).Build(), nodeName: "node-a"},
+		{name: "node dropped if gates are kept", client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(node("node-a", false)).Build(), gates: kept},
+		{name: "unschedulable node", client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(node("node-a", true)).Build()},
+		{name: "missing node", client: fake.NewClientBuilder().WithScheme(scheme).Build()},
+		{name: "failed node lookup", client: failingGetClient{fake.NewClientBuilder().WithScheme(scheme).Build()}, err: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			clone := suspended(test.gates)
+			err := restoreSchedulingState(context.TODO(), test.client, clone)
+
+			if test.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+
+				if _, ok := clone.Annotations[previousSchedulingState]; !ok || clone.Spec.SchedulerName != schedulerName {
+					t.Errorf("expected the recorded state to be kept after a failure, got %v", clone)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if clone.Spec.SchedulerName != "custom" || clone.Spec.NodeName != test.nodeName {
+				t.Errorf("expected scheduler custom and node %q, got %q and %q", test.nodeName, clone.Spec.SchedulerName, clone.Spec.NodeName)
+			}
+
+			if len(clone.Spec.SchedulingGates) != len(test.gates) || (len(test.gates) > 0 && clone.Spec.SchedulingGates[0].Name != "kept") {
+				t.Errorf("expected only the gates kept while suspended to be restored, got %v", clone.Spec.SchedulingGates)
+			}
+
+			if clone.Spec.Priority == nil || *clone.Spec.Priority != priority || len(clone.Spec.Tolerations) != 1 {
+				t.Errorf("expected priority and tolerations to be restored, got %v and %v", clone.Spec.Priority, clone.Spec.Tolerations)
+			}
+
+			if len(clone.Annotations) != 0 {
+				t.Errorf("expected the recorded state to be removed, got %v", clone.Annotations)
+			}
+		})
+	}
+}
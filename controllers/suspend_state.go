@@ -0,0 +1,274 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// namespaceSuspendState is the k8s-pause relevant state of a namespace
+type namespaceSuspendState struct {
+	Suspend   bool
+	Profile   string
+	Protected bool
+
+	// Policy is the name of the NamespacePausePolicy the state is defined by, empty if the state is read from annotations.
+	// The remaining fields can only be set by a policy.
+	Policy             string
+	Strategy           v1beta1.PauseStrategy
+	Ordering           []metav1.LabelSelector
+	GracePeriodSeconds *int64
+	Exclusions         []metav1.LabelSelector
+}
+
+// suspendStateFromNamespace reads the suspend state from the namespace annotations.
+// Protected namespaces are never suspended and have no active profile.
+func suspendStateFromNamespace(ns corev1.Namespace, protected ProtectedNamespaces) namespaceSuspendState {
+	if protected.protects(ns) {
+		return namespaceSuspendState{Protected: true}
+	}
+
+	return namespaceSuspendState{
+		Suspend: ns.Annotations[suspendedAnnotation] == "true",
+		Profile: strings.Join(profileNames(ns.Annotations[profileAnnotation]), ","),
+	}
+}
+
+// SuspendStateCache is an in-memory view of the namespace suspend states, pause policies and resume profiles.
+// It is kept up to date by informer events and allows the webhook to answer without any API lookups.
+type SuspendStateCache struct {
+	protected  ProtectedNamespaces
+	mu         sync.RWMutex
+	namespaces map[string]namespaceSuspendState
+	policies   map[string]map[string]*v1beta1.NamespacePausePolicy
+	profiles   map[client.ObjectKey]*v1beta1.ResumeProfile
+	synced     []toolscache.InformerSynced
+}
+
+// NewSuspendStateCache creates an empty SuspendStateCache
+func NewSuspendStateCache(protected ProtectedNamespaces) *SuspendStateCache {
+	return &SuspendStateCache{
+		protected:  protected,
+		namespaces: make(map[string]namespaceSuspendState),
+		policies:   make(map[string]map[string]*v1beta1.NamespacePausePolicy),
+		profiles:   make(map[client.ObjectKey]*v1beta1.ResumeProfile),
+	}
+}
+
+// SetupWithManager registers the cache on the namespace, pause policy and resume profile informers of the manager
+func (c *SuspendStateCache) SetupWithManager(ctx context.Context, mgr ctrl.Manager) error {
+	nsInformer, err := mgr.GetCache().GetInformer(ctx, &corev1.Namespace{})
+	if err != nil {
+		return fmt.Errorf("failed to get namespace informer: %w", err)
+	}
+
+	_, err = nsInformer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    c.setNamespace,
+		UpdateFunc: func(_, obj interface{}) { c.setNamespace(obj) },
+		DeleteFunc: c.deleteNamespace,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register namespace event handler: %w", err)
+	}
+
+	profileInformer, err := mgr.GetCache().GetInformer(ctx, &v1beta1.ResumeProfile{})
+	if err != nil {
+		return fmt.Errorf("failed to get resume profile informer: %w", err)
+	}
+
+	_, err = profileInformer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    c.setProfile,
+		UpdateFunc: func(_, obj interface{}) { c.setProfile(obj) },
+		DeleteFunc: c.deleteProfile,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register resume profile event handler: %w", err)
+	}
+
+	policyInformer, err := mgr.GetCache().GetInformer(ctx, &v1beta1.NamespacePausePolicy{})
+	if err != nil {
+		return fmt.Errorf("failed to get namespace pause policy informer: %w", err)
+	}
+
+	_, err = policyInformer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    c.setPolicy,
+		UpdateFunc: func(_, obj interface{}) { c.setPolicy(obj) },
+		DeleteFunc: c.deletePolicy,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to register namespace pause policy event handler: %w", err)
+	}
+
+	c.synced = []toolscache.InformerSynced{nsInformer.HasSynced, profileInformer.HasSynced, policyInformer.HasSynced}
+	return nil
+}
+
+// hasSynced returns true once all informers delivered their initial state
+func (c *SuspendStateCache) hasSynced() bool {
+	if len(c.synced) == 0 {
+		return false
+	}
+
+	for _, synced := range c.synced {
+		if !synced() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// namespace returns the suspend state of a namespace, ok is false if the cache can not answer yet
+func (c *SuspendStateCache) namespace(name string) (state namespaceSuspendState, ok bool) {
+	if !c.hasSynced() {
+		return state, false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// Namespaces without any k8s-pause annotations are not stored
+	state = c.namespaces[name]
+	if policies := c.policies[name]; len(policies) > 0 {
+		list := make([]v1beta1.NamespacePausePolicy, 0, len(policies))
+		for _, policy := range policies {
+			list = append(list, *policy)
+		}
+
+		state = state.withPolicy(activePausePolicy(list))
+	}
+
+	return state, true
+}
+
+// profile returns a resume profile, ok is false if the cache can not answer yet
+func (c *SuspendStateCache) profile(key client.ObjectKey) (profile *v1beta1.ResumeProfile, ok bool) {
+	if !c.hasSynced() {
+		return nil, false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.profiles[key], true
+}
+
+func (c *SuspendStateCache) setNamespace(obj interface{}) {
+	ns, ok := obj.(*corev1.Namespace)
+	if !ok {
+		return
+	}
+
+	state := suspendStateFromNamespace(*ns, c.protected)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Protected namespaces are kept since they are protected from pause policies as well
+	if !state.Suspend && state.Profile == "" && !state.Protected {
+		delete(c.namespaces, ns.Name)
+		return
+	}
+
+	c.namespaces[ns.Name] = state
+}
+
+func (c *SuspendStateCache) deleteNamespace(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	ns, ok := obj.(*corev1.Namespace)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.namespaces, ns.Name)
+}
+
+func (c *SuspendStateCache) setProfile(obj interface{}) {
+	profile, ok := obj.(*v1beta1.ResumeProfile)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.profiles[client.ObjectKeyFromObject(profile)] = profile.DeepCopy()
+}
+
+func (c *SuspendStateCache) deleteProfile(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	profile, ok := obj.(*v1beta1.ResumeProfile)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.profiles, client.ObjectKeyFromObject(profile))
+}
+
+func (c *SuspendStateCache) setPolicy(obj interface{}) {
+	policy, ok := obj.(*v1beta1.NamespacePausePolicy)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.policies[policy.Namespace] == nil {
+		c.policies[policy.Namespace] = make(map[string]*v1beta1.NamespacePausePolicy)
+	}
+
+	c.policies[policy.Namespace][policy.Name] = policy.DeepCopy()
+}
+
+func (c *SuspendStateCache) deletePolicy(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	policy, ok := obj.(*v1beta1.NamespacePausePolicy)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.policies[policy.Namespace], policy.Name)
+	if len(c.policies[policy.Namespace]) == 0 {
+		delete(c.policies, policy.Namespace)
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+
+	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
+	"gomodules.xyz/jsonpatch/v2"
+	admissionv1 "k8s.io/api/admission/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:webhook:path=/mutate-v1beta1-suspendrequest,mutating=true,failurePolicy=fail,groups=pause.infra.doodle.com,resources=suspendrequests,verbs=create;update,versions=v1beta1,name=suspendrequest.pause.infra.doodle.com,admissionReviewVersions=v1,sideEffects=None
+
+// SuspendRequestAdmission records the requester and approver of SuspendRequests.
+// Both are taken from the authenticated user of the admission request and can not be set by users.
+type SuspendRequestAdmission struct {
+	decoder *admission.Decoder
+}
+
+// Handle stamps the requested-by and approved-by annotations and prevents self approval
+func (a *SuspendRequestAdmission) Handle(ctx context.Context, req admission.Request) admission.Response {
+	request := &v1beta1.SuspendRequest{}
+	if err := a.decoder.Decode(req, request); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	user := req.UserInfo.Username
+	requester := user
+	approver := ""
+
+	if req.Operation == admissionv1.Update {
+		old := &v1beta1.SuspendRequest{}
+		if err := a.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+
+		specChanged := !reflect.DeepEqual(old.Spec, request.Spec)
+		if specChanged && (old.Status.Phase == v1beta1.SuspendRequestExecuted || old.Status.Phase == v1beta1.SuspendRequestFailed) {
+			return admission.Denied("request has already been processed, create a new SuspendRequest instead")
+		}
+
+		withoutApproval := request.Spec
+		withoutApproval.Approved = old.Spec.Approved
+		if old.Spec.Approved && !reflect.DeepEqual(old.Spec, withoutApproval) {
+			return admission.Denied("an approved request can not be changed, revoke the approval first")
+		}
+
+		requester = old.Annotations[v1beta1.RequestedByAnnotation]
+		if old.Spec.Approved {
+			approver = old.Annotations[v1beta1.ApprovedByAnnotation]
+		}
+	}
+
+	if request.Spec.Approved && approver == "" {
+		if requester != "" && requester == user {
+			return admission.Denied("a request can not be approved by its requester")
+		}
+
+		approver = user
+	}
+
+	annotations := map[string]string{}
+	if requester != "" {
+		annotations[v1beta1.RequestedByAnnotation] = requester
+	}
+
+	if approver != "" {
+		annotations[v1beta1.ApprovedByAnnotation] = approver
+	}
+
+	var patches []jsonpatch.JsonPatchOperation
+	for _, key := range []string{v1beta1.RequestedByAnnotation, v1beta1.ApprovedByAnnotation} {
+		if _, ok := request.Annotations[key]; ok && annotations[key] == "" {
+			patches = append(patches, jsonpatch.NewOperation("remove", "/metadata/annotations/"+escapeJSONPointer(key), nil))
+		}
+	}
+
+	if len(annotations) > 0 {
+		patches = append(patches, annotationPatches(request, annotations)...)
+	}
+
+	if len(patches) == 0 {
+		return admission.Allowed("")
+	}
+
+	return admission.Patched("", patches...)
+}
+
+// InjectDecoder injects the decoder.
+func (a *SuspendRequestAdmission) InjectDecoder(d *admission.Decoder) error {
+	a.decoder = d
+	return nil
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestSuspendRequestAdmission(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hook := &SuspendRequestAdmission{}
+	if err := hook.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+
+	request := v1beta1.SuspendRequest{
+		TypeMeta: metav1.TypeMeta{APIVersion: v1beta1.GroupVersion.String(), Kind: "SuspendRequest"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "request",
+			Annotations: map[string]string{v1beta1.RequestedByAnnotation: "alice"},
+		},
+		Spec: v1beta1.SuspendRequestSpec{Suspend: true},
+	}
+
+	spoofed := *request.DeepCopy()
+	spoofed.Annotations[v1beta1.RequestedByAnnotation] = "mallory"
+	spoofed.Annotations[v1beta1.ApprovedByAnnotation] = "mallory"
+
+	unannotated := *request.DeepCopy()
+	unannotated.Annotations = nil
+
+	approved := *request.DeepCopy()
+	approved.Spec.Approved = true
+
+	approvedChanged := *approved.DeepCopy()
+	approvedChanged.Annotations[v1beta1.ApprovedByAnnotation] = "bob"
+	approvedChanged.Spec.Suspend = false
+
+	executed := *request.DeepCopy()
+	executed.Status.Phase = v1beta1.SuspendRequestExecuted
+
+	resume := *request.DeepCopy()
+	resume.Spec.Suspend = false
+
+	for _, test := range []struct {
+		name     string
+		user     string
+		old      *v1beta1.SuspendRequest
+		new      v1beta1.SuspendRequest
+		allowed  bool
+		expected []string
+	}{
+		{
+			name:    "requester is recorded on create",
+			user:    "alice",
+			new:     unannotated,
+			allowed: true,
+			expected: []string{
+				`{"op":"add","path":"/metadata/annotations","value":{"pause.infra.doodle.com/requested-by":"alice"}}`,
+			},
+		},
+		{
+			name:    "spoofed annotations are replaced on create",
+			user:    "alice",
+			new:     spoofed,
+			allowed: true,
+			expected: []string{
+				`{"op":"remove","path":"/metadata/annotations/pause.infra.doodle.com~1approved-by"}`,
+				`{"op":"add","path":"/metadata/annotations/pause.infra.doodle.com~1requested-by","value":"alice"}`,
+			},
+		},
+		{name: "requester can not approve", user: "alice", old: &request, new: approved, allowed: false},
+		{
+			name:    "approver is recorded",
+			user:    "bob",
+			old:     &request,
+			new:     approved,
+			allowed: true,
+			expected: []string{
+				`{"op":"add","path":"/metadata/annotations/pause.infra.doodle.com~1approved-by","value":"bob"}`,
+			},
+		},
+		{name: "approved request can not be changed", user: "bob", old: &approvedChanged, new: request, allowed: false},
+		{name: "executed request can not be changed", user: "alice", old: &executed, new: resume, allowed: false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			newRaw, err := json.Marshal(test.new)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Operation: admissionv1.Create,
+					UserInfo:  authenticationv1.UserInfo{Username: test.user},
+					Object:    runtime.RawExtension{Raw: newRaw},
+				},
+			}
+
+			if test.old != nil {
+				oldRaw, err := json.Marshal(test.old)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				req.Operation = admissionv1.Update
+				req.OldObject = runtime.RawExtension{Raw: oldRaw}
+			}
+
+			res := hook.Handle(context.TODO(), req)
+			if res.Allowed != test.allowed {
+				t.Fatalf("expected allowed to be %v, got %#v", test.allowed, res.AdmissionResponse)
+			}
+
+			var patches []string
+			for _, patch := range res.Patches {
+				b, err := json.Marshal(patch)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				patches = append(patches, string(b))
+			}
+
+			if fmt.Sprint(patches) != fmt.Sprint(test.expected) {
+				t.Errorf("expected patches %v, got %v", test.expected, patches)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+//+kubebuilder:rbac:groups=pause.infra.doodle.com,resources=suspendrequests,verbs=get;list;watch
+//+kubebuilder:rbac:groups=pause.infra.doodle.com,resources=suspendrequests/status,verbs=get;update;patch
+
+const (
+	// suspendRequestAnnotation holds the name of the SuspendRequest which was last executed on a namespace
+	suspendRequestAnnotation = "k8s-pause/request"
+)
+
+// SuspendRequestReconciler executes SuspendRequests by applying them to the namespace they are created in
+type SuspendRequestReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	opts     SuspendRequestReconcilerOptions
+}
+
+type SuspendRequestReconcilerOptions struct {
+	MaxConcurrentReconciles int
+
+	// RequireApproval only executes requests which have been approved by a user other than the requester
+	RequireApproval bool
+
+	// Protected namespaces are never suspended, requests to suspend them or to activate a ResumeProfile fail
+	Protected ProtectedNamespaces
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *SuspendRequestReconciler) SetupWithManager(mgr ctrl.Manager, opts SuspendRequestReconcilerOptions) error {
+	r.opts = opts
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1beta1.SuspendRequest{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		WithOptions(controller.Options{MaxConcurrentReconciles: opts.MaxConcurrentReconciles}).
+		Complete(r)
+}
+
+// Reconcile applies a SuspendRequest to its namespace.
+// Each request is executed once, executed or failed requests are not applied again.
+func (r *SuspendRequestReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := r.Log.WithValues("Namespace", req.Namespace, "Name", req.Name)
+
+	request := v1beta1.SuspendRequest{}
+	err := r.Client.Get(ctx, req.NamespacedName, &request)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+
+		return reconcile.Result{}, err
+	}
+
+	if request.Status.Phase == v1beta1.SuspendRequestExecuted || request.Status.Phase == v1beta1.SuspendRequestFailed {
+		return ctrl.Result{}, nil
+	}
+
+	updated := request.DeepCopy()
+	updated.Status.ObservedGeneration = request.Generation
+	updated.Status.RequestedBy = request.Annotations[v1beta1.RequestedByAnnotation]
+	updated.Status.ApprovedBy = request.Annotations[v1beta1.ApprovedByAnnotation]
+
+	switch {
+	case r.opts.RequireApproval && (!request.Spec.Approved || updated.Status.ApprovedBy == ""):
+		updated.Status.Phase = v1beta1.SuspendRequestWaitingForApproval
+		updated.Status.Message = "request needs to be approved by a user other than the requester"
+	default:
+		if err := r.execute(ctx, request); err != nil {
+			logger.Error(err, "failed to execute suspend request")
+			r.Recorder.Event(&request, corev1.EventTypeWarning, string(v1beta1.SuspendRequestFailed), err.Error())
+			updated.Status.Phase = v1beta1.SuspendRequestFailed
+			updated.Status.Message = err.Error()
+			break
+		}
+
+		now := metav1.Now()
+		updated.Status.ExecutedAt = &now
+		updated.Status.Phase = v1beta1.SuspendRequestExecuted
+		updated.Status.Message = executedMessage(request)
+		logger.Info("suspend request executed", "suspend", request.Spec.Suspend, "profile", request.Spec.Profile, "requestedBy", updated.Status.RequestedBy)
+		r.Recorder.Event(&request, corev1.EventTypeNormal, string(v1beta1.SuspendRequestExecuted), updated.Status.Message)
+	}
+
+	return ctrl.Result{}, r.Client.Status().Patch(ctx, updated, client.MergeFrom(&request))
+}
+
+// execute applies the request to the NamespacePausePolicy of the namespace, or to the k8s-pause annotations if there is no policy
+func (r *SuspendRequestReconciler) execute(ctx context.Context, request v1beta1.SuspendRequest) error {
+	var ns corev1.Namespace
+	if err := r.Client.Get(ctx, client.ObjectKey{Name: request.Namespace}, &ns); err != nil {
+		return fmt.Errorf("failed to get namespace: %w", err)
+	}
+
+	// Resuming a protected namespace is allowed, it reverts annotations set before the namespace got protected
+	if reason := r.opts.Protected.reason(ns); reason != "" {
+		switch {
+		case request.Spec.Suspend:
+			return fmt.Errorf("namespace can not be suspended: %s", reason)
+		case request.Spec.Profile != "":
+			return fmt.Errorf("namespace can not be resumed using a ResumeProfile: %s", reason)
+		}
+	}
+
+	profiles := profileNames(request.Spec.Profile)
+	for _, name := range profiles {
+		var profile v1beta1.ResumeProfile
+		err := r.Client.Get(ctx, client.ObjectKey{Name: name, Namespace: request.Namespace}, &profile)
+		if err != nil {
+			return fmt.Errorf("failed to get resume profile %s: %w", name, err)
+		}
+	}
+
+	var policies v1beta1.NamespacePausePolicyList
+	if err := r.Client.List(ctx, &policies, client.InNamespace(request.Namespace)); err != nil {
+		return fmt.Errorf("failed to list namespace pause policies: %w", err)
+	}
+
+	if policy := activePausePolicy(policies.Items); policy != nil {
+		return r.executePolicy(ctx, request, *policy, profiles)
+	}
+
+	updated := ns.DeepCopy()
+	if updated.Annotations == nil {
+		updated.Annotations = make(map[string]string)
+	}
+
+	if request.Spec.Suspend {
+		updated.Annotations[suspendedAnnotation] = "true"
+	} else {
+		delete(updated.Annotations, suspendedAnnotation)
+	}
+
+	if len(profiles) > 0 {
+		updated.Annotations[profileAnnotation] = strings.Join(profiles, ",")
+	} else {
+		delete(updated.Annotations, profileAnnotation)
+	}
+
+	updated.Annotations[suspendRequestAnnotation] = request.Name
+
+	if err := r.Client.Patch(ctx, updated, client.MergeFrom(&ns), client.FieldOwner(suspendRequestFieldOwner)); err != nil {
+		return fmt.Errorf("failed to patch namespace: %w", err)
+	}
+
+	return nil
+}
+
+// executePolicy applies the request to the policy which takes precedence over the annotations
+func (r *SuspendRequestReconciler) executePolicy(ctx context.Context, request v1beta1.SuspendRequest, policy v1beta1.NamespacePausePolicy, profiles []string) error {
+	updated := policy.DeepCopy()
+	if updated.Annotations == nil {
+		updated.Annotations = make(map[string]string)
+	}
+
+	updated.Spec.Suspend = request.Spec.Suspend
+	updated.Spec.Profiles = profiles
+	updated.Annotations[suspendRequestAnnotation] = request.Name
+
+	if err := r.Client.Patch(ctx, updated, client.MergeFrom(&policy), client.FieldOwner(suspendRequestFieldOwner)); err != nil {
+		return fmt.Errorf("failed to patch namespace pause policy %s: %w", policy.Name, err)
+	}
+
+	return nil
+}
+
+func executedMessage(request v1beta1.SuspendRequest) string {
+	switch {
+	case request.Spec.Suspend:
+		return "namespace is suspended"
+	case len(profileNames(request.Spec.Profile)) > 1:
+		return fmt.Sprintf("namespace is resumed using ResumeProfiles %s", strings.Join(profileNames(request.Spec.Profile), ", "))
+	case request.Spec.Profile != "":
+		return fmt.Sprintf("namespace is resumed using ResumeProfile %s", request.Spec.Profile)
+	default:
+		return "namespace is resumed"
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newTestSuspendRequestReconciler creates a SuspendRequestReconciler backed by a fake client holding the given objects
+func newTestSuspendRequestReconciler(t *testing.T, opts SuspendRequestReconcilerOptions, objects ...client.Object) *SuspendRequestReconciler {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	return &SuspendRequestReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		Log:      logr.Discard(),
+		Scheme:   scheme,
+		Recorder: record.NewFakeRecorder(100),
+		opts:     opts,
+	}
+}
+
+func TestSuspendRequestExecuteProtected(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		spec    v1beta1.SuspendRequestSpec
+		refused string
+	}{
+		{name: "suspend is refused", spec: v1beta1.SuspendRequestSpec{Suspend: true}, refused: "namespace can not be suspended: namespace is protected"},
+		{name: "resume using a profile is refused", spec: v1beta1.SuspendRequestSpec{Profile: "api"}, refused: "namespace can not be resumed using a ResumeProfile: namespace is protected"},
+		{name: "resume is allowed", spec: v1beta1.SuspendRequestSpec{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", Annotations: map[string]string{suspendedAnnotation: "true"}}}
+			profile := &v1beta1.ResumeProfile{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "kube-system"}}
+			r := newTestSuspendRequestReconciler(t, SuspendRequestReconcilerOptions{Protected: ProtectedNamespaces{Names: []string{"kube-system"}}}, ns, profile)
+
+			request := v1beta1.SuspendRequest{
+				ObjectMeta: metav1.ObjectMeta{Name: "request", Namespace: "kube-system"},
+				Spec:       test.spec,
+			}
+
+			err := r.execute(context.TODO(), request)
+			if test.refused != "" {
+				if err == nil || err.Error() != test.refused {
+					t.Errorf("expected error %q, got %v", test.refused, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var updated corev1.Namespace
+			if err := r.Client.Get(context.TODO(), client.ObjectKeyFromObject(ns), &updated); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, ok := updated.Annotations[suspendedAnnotation]; ok {
+				t.Errorf("expected suspend annotation to be removed, got %v", updated.Annotations)
+			}
+		})
+	}
+}
+
+func TestSuspendRequestExecutePolicy(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "staging"}}
+	policy := &v1beta1.NamespacePausePolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "staging"},
+		Spec:       v1beta1.NamespacePausePolicySpec{Suspend: true, Strategy: v1beta1.PauseStrategyScale},
+	}
+
+	profile := &v1beta1.ResumeProfile{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "staging"}}
+	r := newTestSuspendRequestReconciler(t, SuspendRequestReconcilerOptions{}, ns, policy, profile)
+
+	request := v1beta1.SuspendRequest{
+		ObjectMeta: metav1.ObjectMeta{Name: "request", Namespace: "staging"},
+		Spec:       v1beta1.SuspendRequestSpec{Profile: "api"},
+	}
+
+	if err := r.execute(context.TODO(), request); err != nil {
+		t.Fatal(err)
+	}
+
+	var updated v1beta1.NamespacePausePolicy
+	if err := r.Client.Get(context.TODO(), client.ObjectKeyFromObject(policy), &updated); err != nil {
+		t.Fatal(err)
+	}
+
+	if updated.Spec.Suspend || len(updated.Spec.Profiles) != 1 || updated.Spec.Profiles[0] != "api" || updated.Spec.Strategy != v1beta1.PauseStrategyScale {
+		t.Errorf("expected the policy to be resumed using profile api and to keep its strategy, got %v", updated.Spec)
+	}
+
+	if updated.Annotations[suspendRequestAnnotation] != "request" {
+		t.Errorf("expected the request to be recorded on the policy, got %v", updated.Annotations)
+	}
+
+	var unchanged corev1.Namespace
+	if err := r.Client.Get(context.TODO(), client.ObjectKeyFromObject(ns), &unchanged); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(unchanged.Annotations) != 0 {
+		t.Errorf("expected the namespace annotations not to be touched if a policy exists, got %v", unchanged.Annotations)
+	}
+}
@@ -0,0 +1,253 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+//+kubebuilder:rbac:groups=pause.infra.doodle.com,resources=suspensionhistories,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:groups=pause.infra.doodle.com,resources=suspensionhistories/status,verbs=get;update;patch
+
+const (
+	// suspendRequestFieldOwner is the field manager used to apply SuspendRequests to namespaces.
+	// It allows to attribute annotation changes to the requester of the SuspendRequest.
+	suspendRequestFieldOwner = "k8s-pause-suspendrequest"
+)
+
+// recordedStates remembers the last recorded state of each namespace, it avoids reading the SuspensionHistory on every reconciliation
+type recordedStates struct {
+	mu     sync.Mutex
+	states map[string]recordedState
+}
+
+type recordedState struct {
+	suspended bool
+	profile   string
+}
+
+func (s *recordedStates) recorded(namespace string, state recordedState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	recorded, ok := s.states[namespace]
+	return ok && recorded == state
+}
+
+func (s *recordedStates) remember(namespace string, state recordedState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.states == nil {
+		s.states = make(map[string]recordedState)
+	}
+
+	s.states[namespace] = state
+}
+
+func (s *recordedStates) forget(namespace string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.states, namespace)
+}
+
+// recordTransition appends a transition to the SuspensionHistory of the namespace if the suspend state changed since the last transition.
+// Namespaces which have never been suspended do not get a history.
+func (r *NamespaceReconciler) recordTransition(ctx context.Context, ns corev1.Namespace, state namespaceSuspendState, policy *v1beta1.NamespacePausePolicy) error {
+	if r.opts.HistoryLimit <= 0 || state.Protected {
+		return nil
+	}
+
+	current := recordedState{suspended: state.Suspend, profile: state.Profile}
+	if r.history.recorded(ns.Name, current) {
+		return nil
+	}
+
+	if err := r.appendHistory(ctx, ns, state, policy); err != nil {
+		return err
+	}
+
+	r.history.remember(ns.Name, current)
+	return nil
+}
+
+// appendHistory reads the SuspensionHistory of the namespace and appends the transition if the state differs from the last transition
+func (r *NamespaceReconciler) appendHistory(ctx context.Context, ns corev1.Namespace, state namespaceSuspendState, policy *v1beta1.NamespacePausePolicy) error {
+
+	var history v1beta1.SuspensionHistory
+	err := r.Client.Get(ctx, client.ObjectKey{Name: ns.Name}, &history)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to get suspension history: %w", err)
+	}
+
+	if n := len(history.Status.Transitions); n == 0 {
+		if !state.Suspend && state.Profile == "" {
+			return nil
+		}
+	} else if last := history.Status.Transitions[n-1]; last.Suspended == state.Suspend && last.Profile == state.Profile {
+		return nil
+	}
+
+	if errors.IsNotFound(err) {
+		history = v1beta1.SuspensionHistory{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: ns.Name,
+			},
+		}
+
+		if err := controllerutil.SetOwnerReference(&ns, &history, r.Scheme); err != nil {
+			return err
+		}
+
+		if err := r.Client.Create(ctx, &history); err != nil {
+			return fmt.Errorf("failed to create suspension history: %w", err)
+		}
+	}
+
+	var pods corev1.PodList
+	if err := r.Client.List(ctx, &pods, client.InNamespace(ns.Name)); err != nil {
+		return fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	transition := v1beta1.SuspensionTransition{
+		Suspended: state.Suspend,
+		Profile:   state.Profile,
+		Timestamp: metav1.Now(),
+		Pods:      int32(len(pods.Items)),
+	}
+
+	transition.Actor, transition.Request = r.transitionActor(ctx, ns, state, policy)
+
+	updated := history.DeepCopy()
+	updated.Status.Transitions = appendTransition(updated.Status.Transitions, transition, r.opts.HistoryLimit)
+	return r.Client.Status().Patch(ctx, updated, client.MergeFrom(&history))
+}
+
+// transitionActor determines who changed the k8s-pause annotations of the namespace or the NamespacePausePolicy defining the state.
+// Changes applied from a SuspendRequest are attributed to the requester, any other change to the field manager of the annotations or policy.
+func (r *NamespaceReconciler) transitionActor(ctx context.Context, ns corev1.Namespace, state namespaceSuspendState, policy *v1beta1.NamespacePausePolicy) (actor, request string) {
+	var manager string
+	if state.Policy != "" && policy != nil {
+		manager = policyManager(*policy)
+		request = policy.Annotations[suspendRequestAnnotation]
+	} else {
+		// Removed annotations have no field manager, the request annotation is set by every executed request and attributes resumes as well
+		manager = annotationManager(ns, suspendedAnnotation, profileAnnotation, suspendRequestAnnotation)
+		request = ns.Annotations[suspendRequestAnnotation]
+	}
+
+	if manager != suspendRequestFieldOwner {
+		return manager, ""
+	}
+
+	var suspendRequest v1beta1.SuspendRequest
+	err := r.Client.Get(ctx, client.ObjectKey{Name: request, Namespace: ns.Name}, &suspendRequest)
+	if err != nil {
+		return "", request
+	}
+
+	return suspendRequest.Annotations[v1beta1.RequestedByAnnotation], request
+}
+
+// annotationManager returns the field manager which most recently changed any of the given annotations
+func annotationManager(ns corev1.Namespace, keys ...string) string {
+	return latestManager(ns.ManagedFields, func(raw []byte) bool {
+		var fields struct {
+			Metadata struct {
+				Annotations map[string]json.RawMessage `json:"f:annotations"`
+			} `json:"f:metadata"`
+		}
+
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return false
+		}
+
+		for _, key := range keys {
+			if _, ok := fields.Metadata.Annotations["f:"+key]; ok {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+// policyManager returns the field manager which most recently changed the suspend state or profiles of the policy
+func policyManager(policy v1beta1.NamespacePausePolicy) string {
+	return latestManager(policy.ManagedFields, func(raw []byte) bool {
+		var fields struct {
+			Spec map[string]json.RawMessage `json:"f:spec"`
+		}
+
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return false
+		}
+
+		_, suspend := fields.Spec["f:suspend"]
+		_, profiles := fields.Spec["f:profiles"]
+		return suspend || profiles
+	})
+}
+
+// latestManager returns the most recent field manager whose managed fields are accepted by owns
+func latestManager(entries []metav1.ManagedFieldsEntry, owns func(raw []byte) bool) string {
+	var manager string
+	var latest time.Time
+
+	for _, entry := range entries {
+		if entry.FieldsV1 == nil || !owns(entry.FieldsV1.Raw) {
+			continue
+		}
+
+		if manager == "" || (entry.Time != nil && entry.Time.After(latest)) {
+			manager = entry.Manager
+			if entry.Time != nil {
+				latest = entry.Time.Time
+			}
+		}
+	}
+
+	return manager
+}
+
+// appendTransition adds a transition and drops the oldest transitions if the limit is exceeded.
+// The duration of the previous transition is set since it ends with the new one.
+func appendTransition(transitions []v1beta1.SuspensionTransition, transition v1beta1.SuspensionTransition, limit int) []v1beta1.SuspensionTransition {
+	if n := len(transitions); n > 0 {
+		transitions[n-1].Duration = &metav1.Duration{
+			Duration: transition.Timestamp.Sub(transitions[n-1].Timestamp.Time),
+		}
+	}
+
+	transitions = append(transitions, transition)
+	if len(transitions) > limit {
+		transitions = transitions[len(transitions)-limit:]
+	}
+
+	return transitions
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestAppendTransition(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var transitions []v1beta1.SuspensionTransition
+	for i := 0; i < 5; i++ {
+		transitions = appendTransition(transitions, v1beta1.SuspensionTransition{
+			Suspended: i%2 == 0,
+			Timestamp: metav1.NewTime(start.Add(time.Duration(i) * time.Hour)),
+		}, 3)
+	}
+
+	if len(transitions) != 3 {
+		t.Fatalf("expected 3 transitions, got %d", len(transitions))
+	}
+
+	if !transitions[0].Timestamp.Equal(&metav1.Time{Time: start.Add(2 * time.Hour)}) {
+		t.Errorf("expected the oldest transitions to be dropped, got %v", transitions[0].Timestamp)
+	}
+
+	if transitions[1].Duration == nil || transitions[1].Duration.Duration != time.Hour {
+		t.Errorf("expected duration of 1h, got %v", transitions[1].Duration)
+	}
+
+	if transitions[2].Duration != nil {
+		t.Errorf("expected no duration for the current transition, got %v", transitions[2].Duration)
+	}
+}
+
+func TestAnnotationManager(t *testing.T) {
+	older := metav1.NewTime(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	newer := metav1.NewTime(older.Add(time.Hour))
+
+	ns := corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{
+					Manager:  "kubectl-annotate",
+					Time:     &older,
+					FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:annotations":{"f:k8s-pause/suspend":{}}}}`)},
+				},
+				{
+					Manager:  "helm",
+					Time:     &newer,
+					FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{"f:app":{}}}}`)},
+				},
+				{
+					Manager:  suspendRequestFieldOwner,
+					Time:     &newer,
+					FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:annotations":{"f:k8s-pause/profile":{}}}}`)},
+				},
+			},
+		},
+	}
+
+	if manager := annotationManager(ns, suspendedAnnotation, profileAnnotation); manager != suspendRequestFieldOwner {
+		t.Errorf("expected manager %s, got %s", suspendRequestFieldOwner, manager)
+	}
+
+	if manager := annotationManager(ns, suspendedAnnotation); manager != "kubectl-annotate" {
+		t.Errorf("expected manager kubectl-annotate, got %s", manager)
+	}
+
+	// a resume removes the suspend annotation, the request annotation is still owned by the request
+	ns.ManagedFields[2].FieldsV1.Raw = []byte(`{"f:metadata":{"f:annotations":{"f:k8s-pause/request":{}}}}`)
+	if manager := annotationManager(ns, suspendedAnnotation, profileAnnotation, suspendRequestAnnotation); manager != suspendRequestFieldOwner {
+		t.Errorf("expected manager %s, got %s", suspendRequestFieldOwner, manager)
+	}
+}
+
+// countingClient counts the Get calls per object type
+type countingClient struct {
+	client.WithWatch
+	gets map[string]int
+}
+
+func (c *countingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	c.gets[fmt.Sprintf("%T", obj)]++
+	return c.WithWatch.Get(ctx, key, obj, opts...)
+}
+
+func TestRecordTransition(t *testing.T) {
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "staging",
+			UID:  "uid",
+			ManagedFields: []metav1.ManagedFieldsEntry{
+				{
+					Manager:  "kubectl-annotate",
+					FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:annotations":{"f:k8s-pause/suspend":{}}}}`)},
+				},
+			},
+		},
+	}
+
+	r := newTestNamespaceReconciler(t, NamespaceReconcilerOptions{HistoryLimit: 10}, ns)
+	c := &countingClient{WithWatch: r.Client, gets: make(map[string]int)}
+	r.Client = c
+
+	for _, state := range []namespaceSuspendState{
+		{},
+		{Suspend: true},
+		{Suspend: true},
+		{Profile: "api"},
+		{Profile: "api"},
+	} {
+		if err := r.recordTransition(context.TODO(), *ns, state, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if gets := c.gets["*v1beta1.SuspensionHistory"]; gets != 3 {
+		t.Errorf("expected the history to be read once per state change, got %d reads", gets)
+	}
+
+	var history v1beta1.SuspensionHistory
+	if err := c.WithWatch.Get(context.TODO(), client.ObjectKey{Name: "staging"}, &history); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(history.Status.Transitions); n != 2 {
+		t.Fatalf("expected 2 transitions, got %d", n)
+	}
+
+	if transition := history.Status.Transitions[0]; !transition.Suspended || transition.Actor != "kubectl-annotate" {
+		t.Errorf("expected suspend by kubectl-annotate, got %#v", transition)
+	}
+}
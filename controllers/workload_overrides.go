@@ -0,0 +1,339 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+//+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets,verbs=get;list;update;patch
+
+const (
+	// overrideAnnotation holds the profile whose override is applied to a workload
+	overrideAnnotation = "k8s-pause/override"
+
+	// originalReplicasAnnotation holds the replicas of a workload before the override was applied, empty if replicas were not set
+	originalReplicasAnnotation = "k8s-pause/original-replicas"
+
+	// originalResourcesAnnotation holds the container resources of a workload before the override was applied as json object
+	originalResourcesAnnotation = "k8s-pause/original-resources"
+)
+
+// errSkipWorkload is returned by the match function of overrideWorkloads to leave a workload as it is
+var errSkipWorkload = errors.New("skip workload")
+
+// applyOverrides applies the overrides of the active profile to the Deployments and StatefulSets of the namespace.
+// Workloads which are no longer matched by any override are reverted to their original size.
+func (r *NamespaceReconciler) applyOverrides(ctx context.Context, ns corev1.Namespace, profile *resolvedProfile, logger logr.Logger) error {
+	return r.overrideWorkloads(ctx, ns, profile.override, logger)
+}
+
+// overrideWorkloads applies the override returned by match to each Deployment and StatefulSet of the namespace, workloads without an override are reverted.
+// Workloads for which match returns errSkipWorkload are not touched.
+func (r *NamespaceReconciler) overrideWorkloads(ctx context.Context, ns corev1.Namespace, match func(client.Object) (string, *v1beta1.WorkloadOverride, error), logger logr.Logger) error {
+	var deployments appsv1.DeploymentList
+	if err := r.Client.List(ctx, &deployments, client.InNamespace(ns.Name)); err != nil {
+		return fmt.Errorf("failed to list deployments: %w", err)
+	}
+
+	var statefulSets appsv1.StatefulSetList
+	if err := r.Client.List(ctx, &statefulSets, client.InNamespace(ns.Name)); err != nil {
+		return fmt.Errorf("failed to list statefulsets: %w", err)
+	}
+
+	var workloads []client.Object
+	for i := range deployments.Items {
+		workloads = append(workloads, &deployments.Items[i])
+	}
+
+	for i := range statefulSets.Items {
+		workloads = append(workloads, &statefulSets.Items[i])
+	}
+
+	for _, workload := range workloads {
+		original := workload.DeepCopyObject().(client.Object)
+		_, applied := workload.GetAnnotations()[overrideAnnotation]
+
+		name, override, err := match(workload)
+		if errors.Is(err, errSkipWorkload) {
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if override != nil {
+			err = applyOverride(workload, name, *override)
+		} else if applied {
+			err = revertOverride(workload)
+		} else {
+			continue
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to override %s: %w", workload.GetName(), err)
+		}
+
+		if equality.Semantic.DeepEqual(original, workload) {
+			continue
+		}
+
+		if err := r.Client.Patch(ctx, workload, client.StrategicMergeFrom(original)); err != nil {
+			return fmt.Errorf("failed to patch %s: %w", workload.GetName(), err)
+		}
+
+		logger.Info("workload override changed", "workload", workload.GetName(), "override", workload.GetAnnotations()[overrideAnnotation])
+	}
+
+	return nil
+}
+
+// override returns the first override matching the workload and the profile it originates from.
+// An invalid selector fails all overrides since workloads it was meant to match would be resumed without their override.
+func (p *resolvedProfile) override(workload client.Object) (string, *v1beta1.WorkloadOverride, error) {
+	if p == nil {
+		return "", nil, nil
+	}
+
+	kind, _, _ := workloadSpec(workload)
+	for _, selectors := range p.Selectors {
+		for i, override := range selectors.Overrides {
+			matches, err := matchesOverride(workload, kind, override)
+			if err != nil {
+				return "", nil, fmt.Errorf("invalid override %d of ResumeProfile %s: %w", i, selectors.Profile, err)
+			}
+
+			if matches {
+				return selectors.Profile, &selectors.Overrides[i], nil
+			}
+		}
+	}
+
+	return "", nil, nil
+}
+
+// validateOverrides returns an error for the first override of the profile with an invalid selector
+func validateOverrides(profile resolvedProfile) error {
+	for _, selectors := range profile.Selectors {
+		for i, override := range selectors.Overrides {
+			if override.Selector == nil {
+				continue
+			}
+
+			if _, err := metav1.LabelSelectorAsSelector(override.Selector); err != nil {
+				return fmt.Errorf("invalid override %d of ResumeProfile %s: invalid selector: %w", i, selectors.Profile, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func matchesOverride(workload client.Object, kind string, override v1beta1.WorkloadOverride) (bool, error) {
+	if override.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(override.Selector)
+		if err != nil {
+			return false, fmt.Errorf("invalid selector: %w", err)
+		}
+
+		if selector.Matches(labels.Set(workload.GetLabels())) {
+			return true, nil
+		}
+	}
+
+	for _, selector := range override.Workloads {
+		if selector.Kind == kind && selector.Name == workload.GetName() {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// workloadSpec returns the kind, replicas and pod template of a Deployment or StatefulSet
+func workloadSpec(workload client.Object) (string, **int32, *corev1.PodTemplateSpec) {
+	switch w := workload.(type) {
+	case *appsv1.Deployment:
+		return "Deployment", &w.Spec.Replicas, &w.Spec.Template
+	case *appsv1.StatefulSet:
+		return "StatefulSet", &w.Spec.Replicas, &w.Spec.Template
+	}
+
+	return "", nil, nil
+}
+
+// applyOverride sets the replicas and resources of the override, the original values are recorded the first time an override is applied.
+// Containers added while an override is active are recorded the first time they are seen, before they are overridden.
+// The override is always applied on top of the original values so changes to the override take effect.
+func applyOverride(workload client.Object, profile string, override v1beta1.WorkloadOverride) error {
+	_, replicas, template := workloadSpec(workload)
+	annotations := workload.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+
+	if _, ok := annotations[overrideAnnotation]; !ok {
+		annotations[originalReplicasAnnotation] = ""
+		if *replicas != nil {
+			annotations[originalReplicasAnnotation] = strconv.Itoa(int(**replicas))
+		}
+
+		delete(annotations, originalResourcesAnnotation)
+	}
+
+	resources := make(map[string]corev1.ResourceRequirements)
+	if value, ok := annotations[originalResourcesAnnotation]; ok {
+		if err := json.Unmarshal([]byte(value), &resources); err != nil {
+			return fmt.Errorf("invalid annotation %s: %w", originalResourcesAnnotation, err)
+		}
+	}
+
+	for _, container := range template.Spec.Containers {
+		if _, ok := resources[container.Name]; !ok {
+			resources[container.Name] = container.Resources
+		}
+	}
+
+	b, err := json.Marshal(resources)
+	if err != nil {
+		return err
+	}
+
+	annotations[originalResourcesAnnotation] = string(b)
+
+	annotations[overrideAnnotation] = profile
+	workload.SetAnnotations(annotations)
+
+	if err := restoreOriginals(workload); err != nil {
+		return err
+	}
+
+	if override.Replicas != nil {
+		n := *override.Replicas
+		*replicas = &n
+	}
+
+	for i := range template.Spec.Containers {
+		container := &template.Spec.Containers[i]
+		for _, resources := range override.Resources {
+			if !selectsContainer(resources, container.Name) {
+				continue
+			}
+
+			container.Resources.Requests = overrideResourceList(container.Resources.Requests, resources.Requests)
+			container.Resources.Limits = overrideResourceList(container.Resources.Limits, resources.Limits)
+		}
+	}
+
+	return nil
+}
+
+// revertOverride restores the original replicas and resources and removes the override annotations
+func revertOverride(workload client.Object) error {
+	if err := restoreOriginals(workload); err != nil {
+		return err
+	}
+
+	annotations := workload.GetAnnotations()
+	delete(annotations, overrideAnnotation)
+	delete(annotations, originalReplicasAnnotation)
+	delete(annotations, originalResourcesAnnotation)
+	workload.SetAnnotations(annotations)
+	return nil
+}
+
+// restoreOriginals sets the replicas and resources recorded before the override was applied.
+// Containers which were added while the override was active are kept as they are.
+func restoreOriginals(workload client.Object) error {
+	_, replicas, template := workloadSpec(workload)
+	annotations := workload.GetAnnotations()
+
+	if value, ok := annotations[originalReplicasAnnotation]; ok {
+		if value == "" {
+			*replicas = nil
+		} else {
+			n, err := strconv.ParseInt(value, 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid annotation %s: %w", originalReplicasAnnotation, err)
+			}
+
+			original := int32(n)
+			*replicas = &original
+		}
+	}
+
+	if value, ok := annotations[originalResourcesAnnotation]; ok {
+		var resources map[string]corev1.ResourceRequirements
+		if err := json.Unmarshal([]byte(value), &resources); err != nil {
+			return fmt.Errorf("invalid annotation %s: %w", originalResourcesAnnotation, err)
+		}
+
+		for i := range template.Spec.Containers {
+			if original, ok := resources[template.Spec.Containers[i].Name]; ok {
+				template.Spec.Containers[i].Resources = original
+			}
+		}
+	}
+
+	return nil
+}
+
+func selectsContainer(resources v1beta1.ContainerResourcesOverride, name string) bool {
+	if len(resources.Containers) == 0 {
+		return true
+	}
+
+	for _, container := range resources.Containers {
+		if container == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// overrideResourceList returns a copy of the list with the given resources replaced
+func overrideResourceList(list, override corev1.ResourceList) corev1.ResourceList {
+	if len(override) == 0 {
+		return list
+	}
+
+	result := list.DeepCopy()
+	if result == nil {
+		result = corev1.ResourceList{}
+	}
+
+	for name, quantity := range override {
+		result[name] = quantity.DeepCopy()
+	}
+
+	return result
+}
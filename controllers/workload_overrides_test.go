@@ -0,0 +1,313 @@
+/*
+Copyright 2022 Doodle.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/doodlescheduling/k8s-pause/api/v1beta1"
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestApplyOverrides(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	three := int32(3)
+	one := int32(1)
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "staging", Labels: map[string]string{"tier": "backend"}},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &three,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name: "api",
+							Resources: corev1.ResourceRequirements{
+								Requests: corev1.ResourceList{
+									corev1.ResourceCPU:    resource.MustParse("2"),
+									corev1.ResourceMemory: resource.MustParse("4Gi"),
+								},
+							},
+						},
+						{Name: "proxy"},
+					},
+				},
+			},
+		},
+	}
+
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "postgres", Namespace: "staging"},
+	}
+
+	profile := &resolvedProfile{
+		Name: "reduced",
+		Selectors: []v1beta1.ResolvedSelectors{
+			{
+				Profile: "reduced",
+				Overrides: []v1beta1.WorkloadOverride{
+					{
+						Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "backend"}},
+						Replicas: &one,
+						Resources: []v1beta1.ContainerResourcesOverride{
+							{
+								Containers: []string{"api"},
+								Requests:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+							},
+						},
+					},
+					{
+						Workloads: []v1beta1.WorkloadSelector{{Kind: "StatefulSet", Name: "postgres"}},
+						Replicas:  &one,
+					},
+				},
+			},
+		},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(deployment, statefulSet).Build()
+	r := &NamespaceReconciler{Client: c}
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "staging"}}
+
+	if err := r.applyOverrides(context.TODO(), ns, profile, logr.Discard()); err != nil {
+		t.Fatal(err)
+	}
+
+	// applying the override again must not change the recorded original values
+	if err := r.applyOverrides(context.TODO(), ns, profile, logr.Discard()); err != nil {
+		t.Fatal(err)
+	}
+
+	var overridden appsv1.Deployment
+	if err := c.Get(context.TODO(), client.ObjectKeyFromObject(deployment), &overridden); err != nil {
+		t.Fatal(err)
+	}
+
+	if *overridden.Spec.Replicas != 1 {
+		t.Errorf("expected 1 replica, got %d", *overridden.Spec.Replicas)
+	}
+
+	requests := overridden.Spec.Template.Spec.Containers[0].Resources.Requests
+	if requests.Cpu().String() != "100m" || requests.Memory().String() != "4Gi" {
+		t.Errorf("expected cpu request to be overridden and memory request to be kept, got %v", requests)
+	}
+
+	if overridden.Spec.Template.Spec.Containers[1].Resources.Requests != nil {
+		t.Errorf("expected proxy container not to be overridden, got %v", overridden.Spec.Template.Spec.Containers[1].Resources)
+	}
+
+	if overridden.Annotations[overrideAnnotation] != "reduced" || overridden.Annotations[originalReplicasAnnotation] != "3" {
+		t.Errorf("unexpected annotations %v", overridden.Annotations)
+	}
+
+	var overriddenSet appsv1.StatefulSet
+	if err := c.Get(context.TODO(), client.ObjectKeyFromObject(statefulSet), &overriddenSet); err != nil {
+		t.Fatal(err)
+	}
+
+	if overriddenSet.Spec.Replicas == nil || *overriddenSet.Spec.Replicas != 1 {
+		t.Errorf("expected statefulset to be scaled to 1 replica, got %v", overriddenSet.Spec.Replicas)
+	}
+
+	if err := r.applyOverrides(context.TODO(), ns, nil, logr.Discard()); err != nil {
+		t.Fatal(err)
+	}
+
+	var reverted appsv1.Deployment
+	if err := c.Get(context.TODO(), client.ObjectKeyFromObject(deployment), &reverted); err != nil {
+		t.Fatal(err)
+	}
+
+	if *reverted.Spec.Replicas != 3 {
+		t.Errorf("expected 3 replicas after revert, got %d", *reverted.Spec.Replicas)
+	}
+
+	if cpu := reverted.Spec.Template.Spec.Containers[0].Resources.Requests.Cpu().String(); cpu != "2" {
+		t.Errorf("expected cpu request 2 after revert, got %s", cpu)
+	}
+
+	if _, ok := reverted.Annotations[overrideAnnotation]; ok {
+		t.Errorf("expected override annotations to be removed, got %v", reverted.Annotations)
+	}
+
+	var revertedSet appsv1.StatefulSet
+	if err := c.Get(context.TODO(), client.ObjectKeyFromObject(statefulSet), &revertedSet); err != nil {
+		t.Fatal(err)
+	}
+
+	if revertedSet.Spec.Replicas != nil {
+		t.Errorf("expected unset replicas after revert, got %d", *revertedSet.Spec.Replicas)
+	}
+}
+
+func TestApplyOverrideAddedContainer(t *testing.T) {
+	requests := func(cpu string) corev1.ResourceRequirements {
+		return corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)}}
+	}
+
+	override := v1beta1.WorkloadOverride{
+		Resources: []v1beta1.ContainerResourcesOverride{
+			{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}},
+		},
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "staging"},
+		Spec: appsv1.DeploymentSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "api", Resources: requests("2")}}},
+			},
+		},
+	}
+
+	if err := applyOverride(deployment, "reduced", override); err != nil {
+		t.Fatal(err)
+	}
+
+	// A container added while the override is active is recorded before it is overridden
+	deployment.Spec.Template.Spec.Containers = append(deployment.Spec.Template.Spec.Containers, corev1.Container{Name: "sidecar", Resources: requests("1")})
+	if err := applyOverride(deployment, "reduced", override); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		if cpu := container.Resources.Requests.Cpu().String(); cpu != "100m" {
+			t.Errorf("expected container %s to be overridden, got %s", container.Name, cpu)
+		}
+	}
+
+	if err := revertOverride(deployment); err != nil {
+		t.Fatal(err)
+	}
+
+	for container, expected := range map[int]string{0: "2", 1: "1"} {
+		if cpu := deployment.Spec.Template.Spec.Containers[container].Resources.Requests.Cpu().String(); cpu != expected {
+			t.Errorf("expected container %s to be reverted to %s, got %s", deployment.Spec.Template.Spec.Containers[container].Name, expected, cpu)
+		}
+	}
+}
+
+func TestApplyOverridesInvalidSelector(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	three := int32(3)
+	one := int32(1)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "staging"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &three},
+	}
+
+	profile := &resolvedProfile{
+		Name: "reduced",
+		Selectors: []v1beta1.ResolvedSelectors{
+			{
+				Profile: "reduced",
+				Overrides: []v1beta1.WorkloadOverride{
+					{
+						Selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "tier", Operator: "Bogus"}}},
+						Replicas: &one,
+					},
+				},
+			},
+		},
+	}
+
+	if err := validateOverrides(*profile); err == nil {
+		t.Error("expected invalid selector to be reported")
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(deployment).Build()
+	r := &NamespaceReconciler{Client: c}
+	if err := r.applyOverrides(context.TODO(), corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "staging"}}, profile, logr.Discard()); err == nil {
+		t.Error("expected invalid selector to fail the overrides")
+	}
+
+	var unchanged appsv1.Deployment
+	if err := c.Get(context.TODO(), client.ObjectKeyFromObject(deployment), &unchanged); err != nil {
+		t.Fatal(err)
+	}
+
+	if *unchanged.Spec.Replicas != 3 {
+		t.Errorf("expected workload not to be changed, got %d replicas", *unchanged.Spec.Replicas)
+	}
+}
+
+func TestReconcileOverridesBeforeResume(t *testing.T) {
+	three := int32(3)
+	one := int32(1)
+	owner := []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "api-7d9f", UID: "uid"}}
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "staging", Annotations: map[string]string{profileAnnotation: "reduced"}}}
+	profile := &v1beta1.ResumeProfile{
+		ObjectMeta: metav1.ObjectMeta{Name: "reduced", Namespace: "staging"},
+		Spec: v1beta1.ResumeProfileSpec{
+			ProfileSelectors: v1beta1.ProfileSelectors{
+				PodSelector: []metav1.LabelSelector{{MatchLabels: map[string]string{"app": "api"}}},
+			},
+			Overrides: []v1beta1.WorkloadOverride{
+				{Workloads: []v1beta1.WorkloadSelector{{Kind: "Deployment", Name: "api"}}, Replicas: &one},
+			},
+		},
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "staging"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &three},
+	}
+
+	parked := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-7d9f-x7k2p", Namespace: "staging", Labels: map[string]string{"app": "api"}, OwnerReferences: owner},
+		Spec:       corev1.PodSpec{SchedulerName: schedulerName},
+		Status:     corev1.PodStatus{Phase: phaseSuspended},
+	}
+
+	r := newTestNamespaceReconciler(t, NamespaceReconcilerOptions{}, ns, profile, deployment, parked)
+	c := r.Client
+	r.Client = failingDeleteClient{WithWatch: c, names: map[string]bool{parked.Name: true}}
+
+	// The override is in place even though resuming the pod failed
+	if _, err := r.Reconcile(context.TODO(), ctrl.Request{NamespacedName: client.ObjectKey{Name: "staging"}}); err == nil {
+		t.Fatal("expected the failed pod to be reported")
+	}
+
+	var overridden appsv1.Deployment
+	if err := c.Get(context.TODO(), client.ObjectKeyFromObject(deployment), &overridden); err != nil {
+		t.Fatal(err)
+	}
+
+	if *overridden.Spec.Replicas != 1 {
+		t.Errorf("expected the override to be applied before pods are resumed, got %d replicas", *overridden.Spec.Replicas)
+	}
+}
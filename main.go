@@ -20,6 +20,7 @@ import (
 	"flag"
 	"os"
 	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -27,6 +28,7 @@ import (
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -47,6 +49,10 @@ var (
 	setupLog = ctrl.Log.WithName("setup")
 )
 
+const (
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(v1beta1.AddToScheme(scheme))
@@ -61,6 +67,19 @@ var (
 	leaderElectionNamespace string
 	namespaces              = ""
 	concurrent              = 2
+	suspendMode             = string(controllers.SuspendModeScheduler)
+	resumeStrategy          = string(controllers.ResumeStrategyRecreate)
+	podsPerSecond           = 0.0
+	batchSize               = 0
+	resyncInterval          = 5 * time.Minute
+	webhookOnError          = string(controllers.WebhookErrorPolicyDeny)
+	protectedNamespaces     = "kube-system"
+	protectedSelector       = ""
+	podNamespace            = ""
+	dependencyNamespaces    = "cert-manager"
+	requireApproval         = false
+	notificationHosts       = ""
+	historyLimit            = 20
 )
 
 func main() {
@@ -75,6 +94,32 @@ func main() {
 		"The controller listens by default for all namespaces. This may be limited to a comma delimted list of dedicated namespaces.")
 	flag.IntVar(&concurrent, "concurrent", concurrent,
 		"The number of concurrent reconcile workers. By default this is 2.")
+	flag.StringVar(&suspendMode, "suspend-mode", suspendMode,
+		"How pods are prevented from being scheduled, either scheduler or gate. The gate mode uses scheduling gates and requires Kubernetes 1.27+.")
+	flag.StringVar(&resumeStrategy, "resume-strategy", resumeStrategy,
+		"How pods owned by a controller are resumed, either recreate or in-place. The in-place strategy only applies to pods suspended by the gate mode.")
+	flag.Float64Var(&podsPerSecond, "pods-per-second", podsPerSecond,
+		"The maximum number of pods suspended or resumed per second across all namespaces. By default this is unlimited.")
+	flag.IntVar(&batchSize, "batch-size", batchSize,
+		"The maximum number of pods suspended or resumed per reconciliation, remaining pods are processed afterwards. By default this is unlimited.")
+	flag.DurationVar(&resyncInterval, "resync-interval", resyncInterval,
+		"How often suspended namespaces are verified for running pods. Set to 0 to disable the resync.")
+	flag.StringVar(&webhookOnError, "webhook-on-error", webhookOnError,
+		"How pods are admitted if the webhook fails to determine whether they must be suspended, either allow, deny or suspend.")
+	flag.StringVar(&protectedNamespaces, "protected-namespaces", protectedNamespaces,
+		"A comma delimited list of namespaces which are never suspended, even if they are annotated.")
+	flag.StringVar(&protectedSelector, "protected-namespace-selector", protectedSelector,
+		"A label selector for namespaces which are never suspended, even if they are annotated.")
+	flag.StringVar(&podNamespace, "pod-namespace", podNamespace,
+		"The namespace k8s-pause is running in, it is never suspended. Usually set using the downward API, by default the namespace of the service account is used.")
+	flag.StringVar(&dependencyNamespaces, "dependency-namespaces", dependencyNamespaces,
+		"A comma delimited list of namespaces hosting dependencies of the webhook like cert-manager, these are never suspended.")
+	flag.BoolVar(&requireApproval, "require-approval", requireApproval,
+		"Only execute SuspendRequests which have been approved by a user other than the requester.")
+	flag.IntVar(&historyLimit, "history-limit", historyLimit,
+		"The number of suspend and resume transitions kept in the SuspensionHistory of a namespace. Set to 0 to disable the history.")
+	flag.StringVar(&notificationHosts, "notification-allowed-hosts", notificationHosts,
+		"A comma delimited list of hosts notifications may be sent to, a leading *. matches any subdomain. All hosts except private addresses are allowed if empty.")
 
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 	pflag.Parse()
@@ -116,6 +161,64 @@ func main() {
 		os.Exit(1)
 	}
 
+	mode := controllers.SuspendMode(viper.GetString("suspend-mode"))
+	if mode != controllers.SuspendModeScheduler && mode != controllers.SuspendModeSchedulingGate {
+		setupLog.Error(nil, "unsupported suspend mode", "mode", mode)
+		os.Exit(1)
+	}
+
+	strategy := controllers.ResumeStrategy(viper.GetString("resume-strategy"))
+	if strategy != controllers.ResumeStrategyRecreate && strategy != controllers.ResumeStrategyInPlace {
+		setupLog.Error(nil, "unsupported resume strategy", "strategy", strategy)
+		os.Exit(1)
+	}
+
+	onError := controllers.WebhookErrorPolicy(viper.GetString("webhook-on-error"))
+	if onError != controllers.WebhookErrorPolicyAllow && onError != controllers.WebhookErrorPolicyDeny && onError != controllers.WebhookErrorPolicySuspend {
+		setupLog.Error(nil, "unsupported webhook error policy", "policy", onError)
+		os.Exit(1)
+	}
+
+	selector, err := labels.Parse(viper.GetString("protected-namespace-selector"))
+	if err != nil {
+		setupLog.Error(err, "invalid protected namespace selector")
+		os.Exit(1)
+	}
+
+	protected := controllers.ProtectedNamespaces{
+		Self:     viper.GetString("pod-namespace"),
+		Selector: selector,
+	}
+
+	if protected.Self == "" {
+		if b, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+			protected.Self = strings.TrimSpace(string(b))
+		}
+	}
+
+	if protected.Self == "" {
+		setupLog.Info("could not detect own namespace, make sure it is protected from being suspended")
+	}
+
+	for _, name := range strings.Split(viper.GetString("dependency-namespaces"), ",") {
+		if name != "" {
+			protected.Dependencies = append(protected.Dependencies, name)
+		}
+	}
+
+	for _, name := range strings.Split(viper.GetString("protected-namespaces"), ",") {
+		if name != "" {
+			protected.Names = append(protected.Names, name)
+		}
+	}
+
+	var allowedHosts []string
+	for _, host := range strings.Split(viper.GetString("notification-allowed-hosts"), ",") {
+		if host != "" {
+			allowedHosts = append(allowedHosts, host)
+		}
+	}
+
 	if err = (&controllers.PodReconciler{
 		Client: mgr.GetClient(),
 		Log:    ctrl.Log.WithName("controllers").WithName("Pod"),
@@ -136,14 +239,63 @@ func main() {
 	}
 
 	if err = (&controllers.NamespaceReconciler{
-		Client: client,
-		Log:    ctrl.Log.WithName("controllers").WithName("Namespace"),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr, controllers.NamespaceReconcilerOptions{MaxConcurrentReconciles: viper.GetInt("concurrent")}); err != nil {
+		Client:   client,
+		Log:      ctrl.Log.WithName("controllers").WithName("Namespace"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("k8s-pause"),
+		Notifier: &controllers.Notifier{
+			Client:       mgr.GetAPIReader(),
+			Log:          ctrl.Log.WithName("notifier"),
+			Recorder:     mgr.GetEventRecorderFor("k8s-pause"),
+			AllowedHosts: allowedHosts,
+		},
+	}).SetupWithManager(mgr, controllers.NamespaceReconcilerOptions{
+		MaxConcurrentReconciles: viper.GetInt("concurrent"),
+		SuspendMode:             mode,
+		ResumeStrategy:          strategy,
+		PodsPerSecond:           viper.GetFloat64("pods-per-second"),
+		BatchSize:               viper.GetInt("batch-size"),
+		ResyncInterval:          viper.GetDuration("resync-interval"),
+		Protected:               protected,
+		HistoryLimit:            viper.GetInt("history-limit"),
+	}); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Namespace")
 		os.Exit(1)
 	}
 
+	if err = (&controllers.SuspendRequestReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("SuspendRequest"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("k8s-pause"),
+	}).SetupWithManager(mgr, controllers.SuspendRequestReconcilerOptions{
+		MaxConcurrentReconciles: viper.GetInt("concurrent"),
+		RequireApproval:         viper.GetBool("require-approval"),
+		Protected:               protected,
+	}); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "SuspendRequest")
+		os.Exit(1)
+	}
+
+	if err = (&controllers.ResumeProfileReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("ResumeProfile"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr, controllers.ResumeProfileReconcilerOptions{
+		MaxConcurrentReconciles: viper.GetInt("concurrent"),
+	}); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ResumeProfile")
+		os.Exit(1)
+	}
+
+	ctx := ctrl.SetupSignalHandler()
+
+	state := controllers.NewSuspendStateCache(protected)
+	if err := state.SetupWithManager(ctx, mgr); err != nil {
+		setupLog.Error(err, "unable to setup suspend state cache")
+		os.Exit(1)
+	}
+
 	// Setup webhooks
 	setupLog.Info("setting up webhook server")
 	hookServer := mgr.GetWebhookServer()
@@ -151,10 +303,18 @@ func main() {
 	setupLog.Info("registering webhooks to the webhook server")
 	hookServer.Register("/mutate-v1-pod", &webhook.Admission{
 		Handler: &controllers.Scheduler{
-			Client: mgr.GetClient(),
+			Client:    mgr.GetClient(),
+			Mode:      mode,
+			OnError:   onError,
+			Protected: protected,
+			State:     state,
 		},
 	})
 
+	hookServer.Register("/mutate-v1beta1-suspendrequest", &webhook.Admission{
+		Handler: &controllers.SuspendRequestAdmission{},
+	})
+
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -167,7 +327,7 @@ func main() {
 	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(ctx); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}